@@ -0,0 +1,136 @@
+package vcsstate
+
+import "fmt"
+
+// BranchInfo describes what is checked out in the working directory.
+type BranchInfo struct {
+	State    BranchState
+	Name     string // Branch name. Empty if State is Detached.
+	Revision string // Checked out revision. Empty if State is Unborn.
+}
+
+// BranchState is the state of the checked out branch.
+type BranchState uint8
+
+const (
+	// Named means a named branch is checked out.
+	Named BranchState = iota
+
+	// Detached means no branch is checked out, only a revision.
+	// For git, this is a detached HEAD. Mercurial always has a named branch
+	// checked out, so for hg this means the working directory parent
+	// is not a head of its branch.
+	Detached
+
+	// Unborn means a named branch is checked out, but it has no commits yet,
+	// e.g., in a freshly initialized repository.
+	Unborn
+)
+
+func (s BranchState) String() string {
+	switch s {
+	case Named:
+		return "named"
+	case Detached:
+		return "detached"
+	case Unborn:
+		return "unborn"
+	default:
+		return fmt.Sprintf("BranchState(%d)", uint8(s))
+	}
+}
+
+// DefaultBranchSource describes how a guess of the default branch was derived.
+type DefaultBranchSource uint8
+
+const (
+	// Builtin means the vcs's built-in default was used, as returned by NoRemoteDefaultBranch.
+	Builtin DefaultBranchSource = iota
+
+	// Config means the init.defaultBranch configuration was used.
+	Config
+
+	// LocalBranch means a conventionally named branch that exists locally was used.
+	LocalBranch
+)
+
+func (s DefaultBranchSource) String() string {
+	switch s {
+	case Builtin:
+		return "builtin"
+	case Config:
+		return "config"
+	case LocalBranch:
+		return "local branch"
+	default:
+		return fmt.Sprintf("DefaultBranchSource(%d)", uint8(s))
+	}
+}
+
+// conventionalDefaultBranches are conventional names of default branches,
+// in order of preference.
+var conventionalDefaultBranches = []string{"main", "master", "trunk"}
+
+// guessDefaultBranch guesses the default branch as described by GuessDefaultBranch.
+// configured is the value of init.defaultBranch, or empty if it's not set,
+// exist reports which of the configured and conventional branches exist locally,
+// and builtin is the value of NoRemoteDefaultBranch.
+func guessDefaultBranch(configured string, exist map[string]bool, builtin string) (string, DefaultBranchSource) {
+	if configured != "" && exist[configured] {
+		return configured, Config
+	}
+	for _, b := range conventionalDefaultBranches {
+		if exist[b] {
+			return b, LocalBranch
+		}
+	}
+	if configured != "" {
+		return configured, Config
+	}
+	return builtin, Builtin
+}
+
+// RemoteHead describes the default branch of a remote repository.
+type RemoteHead struct {
+	Branch     string                  // Default branch name.
+	Revision   string                  // Latest revision of default branch.
+	Convention DefaultBranchConvention // How the default branch was determined.
+}
+
+// DefaultBranchConvention is the convention by which the default branch
+// of a remote repository was determined.
+type DefaultBranchConvention uint8
+
+const (
+	// SymbolicRef means the git remote reported that its HEAD is a symbolic ref
+	// to the default branch.
+	SymbolicRef DefaultBranchConvention = iota
+
+	// RevisionMatch means the git remote didn't report where its HEAD points,
+	// so the default branch is a branch whose latest revision matches HEAD's.
+	RevisionMatch
+
+	// Bookmark means the hg remote has an "@" bookmark, which is checked out
+	// by default on clone. The default branch is the named branch that "@"
+	// is on, or "default" if that can't be determined locally.
+	Bookmark
+
+	// BranchTip means the hg remote has no "@" bookmark, so the default branch
+	// is the "default" named branch, and its latest revision is the branch tip.
+	BranchTip
+)
+
+func (c DefaultBranchConvention) String() string {
+	switch c {
+	case SymbolicRef:
+		return "symbolic ref"
+	case RevisionMatch:
+		return "revision match"
+	case Bookmark:
+		return "bookmark"
+	case BranchTip:
+		return "branch tip"
+	default:
+		return fmt.Sprintf("DefaultBranchConvention(%d)", uint8(c))
+	}
+}
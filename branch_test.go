@@ -0,0 +1,31 @@
+package vcsstate
+
+import "testing"
+
+func TestGuessDefaultBranch(t *testing.T) {
+	tests := []struct {
+		configured string
+		exist      []string
+		wantBranch string
+		wantSource DefaultBranchSource
+	}{
+		{"", nil, "master", Builtin},
+		{"", []string{"feature"}, "master", Builtin},
+		{"", []string{"trunk", "master"}, "master", LocalBranch},
+		{"", []string{"trunk", "master", "main"}, "main", LocalBranch},
+		{"", []string{"trunk"}, "trunk", LocalBranch},
+		{"develop", []string{"develop", "main"}, "develop", Config},
+		{"develop", []string{"main"}, "main", LocalBranch},
+		{"develop", nil, "develop", Config},
+	}
+	for _, tc := range tests {
+		exist := make(map[string]bool)
+		for _, b := range tc.exist {
+			exist[b] = true
+		}
+		branch, source := guessDefaultBranch(tc.configured, exist, "master")
+		if branch != tc.wantBranch || source != tc.wantSource {
+			t.Errorf("guessDefaultBranch(%q, %q): got %q, %v, want %q, %v", tc.configured, tc.exist, branch, source, tc.wantBranch, tc.wantSource)
+		}
+	}
+}
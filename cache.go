@@ -0,0 +1,216 @@
+package vcsstate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RemoteCache is a persistent on-disk cache of RemoteHead results,
+// keyed by remote URL. It's safe for concurrent use, including by multiple processes
+// sharing the same directory.
+//
+// Use its VCS and RemoteVCS methods to add caching to a VCS or RemoteVCS.
+type RemoteCache struct {
+	// Dir is the directory where cache entries are stored.
+	// If empty, the "vcsstate" subdirectory of os.UserCacheDir is used.
+	Dir string
+
+	// TTL is how long a cache entry remains fresh after it's recorded.
+	// Fresh entries are returned by RemoteHead and RemoteBranchAndRevision
+	// without using network.
+	// If zero, entries are never fresh, and they're only used as a fallback
+	// by CachedRemoteDefaultBranch.
+	TTL time.Duration
+}
+
+// cacheEntry is a recorded result of RemoteHead.
+type cacheEntry struct {
+	URL        string
+	Branch     string
+	Revision   string
+	Convention DefaultBranchConvention
+	Time       time.Time // Time when the result was recorded.
+}
+
+// VCS returns a VCS that behaves like v, except its RemoteHead and
+// RemoteBranchAndRevision use fresh entries from the cache and records new results in it,
+// and its CachedRemoteDefaultBranch prefers the latest recorded result
+// for the remote URL, regardless of its age.
+func (c *RemoteCache) VCS(v VCS) VCS {
+	return cachedVCS{VCS: v, c: c}
+}
+
+// RemoteVCS returns a RemoteVCS that behaves like rv, except its RemoteHead
+// and RemoteBranchAndRevision use fresh entries from the cache
+// and record new results in it.
+func (c *RemoteCache) RemoteVCS(rv RemoteVCS) RemoteVCS {
+	return cachedRemoteVCS{rv: rv, c: c}
+}
+
+// Invalidate removes the cache entry for remoteURL, if any.
+func (c *RemoteCache) Invalidate(remoteURL string) error {
+	path, err := c.path(remoteURL)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// remoteHead returns a fresh cached result for remoteURL if one exists.
+// Otherwise, it calls query and records a successful result.
+func (c *RemoteCache) remoteHead(remoteURL string, query func() (RemoteHead, error)) (RemoteHead, error) {
+	if e, err := c.load(remoteURL); err == nil && time.Since(e.Time) < c.TTL {
+		return RemoteHead{Branch: e.Branch, Revision: e.Revision, Convention: e.Convention}, nil
+	}
+	head, err := query()
+	if err != nil {
+		return RemoteHead{}, err
+	}
+	// Failing to record the result doesn't affect its correctness, so ignore the error.
+	_ = c.store(cacheEntry{URL: remoteURL, Branch: head.Branch, Revision: head.Revision, Convention: head.Convention, Time: time.Now()})
+	return head, nil
+}
+
+// path returns the path of the cache entry file for remoteURL.
+func (c *RemoteCache) path(remoteURL string) (string, error) {
+	dir := c.Dir
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(userCache, "vcsstate")
+	}
+	sum := sha256.Sum256([]byte(remoteURL))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// load loads the cache entry for remoteURL.
+func (c *RemoteCache) load(remoteURL string) (cacheEntry, error) {
+	path, err := c.path(remoteURL)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	var e cacheEntry
+	err = json.Unmarshal(b, &e)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	if e.URL != remoteURL {
+		return cacheEntry{}, errors.New("cache entry is for a different remote URL")
+	}
+	return e, nil
+}
+
+// store records cache entry e. It writes to a temporary file first,
+// so that concurrent readers never see a partially written entry.
+func (c *RemoteCache) store(e cacheEntry) error {
+	path, err := c.path(e.URL)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// cachedVCS is a VCS that uses RemoteCache c.
+type cachedVCS struct {
+	VCS
+	c *RemoteCache
+}
+
+func (v cachedVCS) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	return v.RemoteBranchAndRevisionContext(context.Background(), dir)
+}
+
+func (v cachedVCS) RemoteBranchAndRevisionContext(ctx context.Context, dir string) (branch string, revision string, err error) {
+	head, err := v.RemoteHeadContext(ctx, dir)
+	return head.Branch, head.Revision, err
+}
+
+func (v cachedVCS) RemoteHead(dir string) (RemoteHead, error) {
+	return v.RemoteHeadContext(context.Background(), dir)
+}
+
+func (v cachedVCS) RemoteHeadContext(ctx context.Context, dir string) (RemoteHead, error) {
+	remoteURL, err := v.VCS.RemoteURLContext(ctx, dir)
+	if err != nil {
+		return RemoteHead{}, err
+	}
+	return v.c.remoteHead(remoteURL, func() (RemoteHead, error) {
+		return v.VCS.RemoteHeadContext(ctx, dir)
+	})
+}
+
+func (v cachedVCS) CachedRemoteDefaultBranch(dir string) (string, error) {
+	return v.CachedRemoteDefaultBranchContext(context.Background(), dir)
+}
+
+func (v cachedVCS) CachedRemoteDefaultBranchContext(ctx context.Context, dir string) (string, error) {
+	remoteURL, err := v.VCS.RemoteURLContext(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	if e, err := v.c.load(remoteURL); err == nil {
+		return e.Branch, nil
+	}
+	return v.VCS.CachedRemoteDefaultBranchContext(ctx, dir)
+}
+
+// cachedRemoteVCS is a RemoteVCS that uses RemoteCache c.
+type cachedRemoteVCS struct {
+	rv RemoteVCS
+	c  *RemoteCache
+}
+
+func (v cachedRemoteVCS) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
+	return v.RemoteBranchAndRevisionContext(context.Background(), remoteURL)
+}
+
+func (v cachedRemoteVCS) RemoteBranchAndRevisionContext(ctx context.Context, remoteURL string) (branch string, revision string, err error) {
+	head, err := v.RemoteHeadContext(ctx, remoteURL)
+	return head.Branch, head.Revision, err
+}
+
+func (v cachedRemoteVCS) RemoteHead(remoteURL string) (RemoteHead, error) {
+	return v.RemoteHeadContext(context.Background(), remoteURL)
+}
+
+func (v cachedRemoteVCS) RemoteHeadContext(ctx context.Context, remoteURL string) (RemoteHead, error) {
+	return v.c.remoteHead(remoteURL, func() (RemoteHead, error) {
+		return v.rv.RemoteHeadContext(ctx, remoteURL)
+	})
+}
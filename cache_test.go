@@ -0,0 +1,109 @@
+package vcsstate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingRemote is a RemoteVCS backend that counts its calls.
+type countingRemote struct {
+	calls *int
+}
+
+func (r countingRemote) RemoteHeadContext(ctx context.Context, remoteURL string) (RemoteHead, error) {
+	*r.calls++
+	return RemoteHead{Branch: "main", Revision: "7cafcd837844e784b526369c9bce262804aebc60", Convention: SymbolicRef}, nil
+}
+
+// fixedRemoteVCS is a VCS whose remote-facing methods return fixed results.
+// Other methods are not implemented.
+type fixedRemoteVCS struct {
+	VCS
+	calls *int
+}
+
+func (v fixedRemoteVCS) RemoteURLContext(ctx context.Context, dir string) (string, error) {
+	return "https://example.com/repo", nil
+}
+
+func (v fixedRemoteVCS) RemoteHeadContext(ctx context.Context, dir string) (RemoteHead, error) {
+	*v.calls++
+	return RemoteHead{Branch: "main", Revision: "7cafcd837844e784b526369c9bce262804aebc60", Convention: SymbolicRef}, nil
+}
+
+func (v fixedRemoteVCS) CachedRemoteDefaultBranchContext(ctx context.Context, dir string) (string, error) {
+	return "master", nil
+}
+
+func TestRemoteCacheRemoteVCS(t *testing.T) {
+	c := &RemoteCache{Dir: t.TempDir(), TTL: time.Hour}
+	var calls int
+	rv := c.RemoteVCS(backgroundRemoteVCS{countingRemote{calls: &calls}})
+
+	for i := 0; i < 3; i++ {
+		branch, revision, err := rv.RemoteBranchAndRevision("https://example.com/repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if branch != "main" || revision != "7cafcd837844e784b526369c9bce262804aebc60" {
+			t.Errorf("got %q, %q", branch, revision)
+		}
+		head, err := rv.RemoteHead("https://example.com/repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := head.Convention, SymbolicRef; got != want {
+			t.Errorf("got convention %v, want %v", got, want)
+		}
+	}
+	if got, want := calls, 1; got != want {
+		t.Errorf("got %v calls, want %v", got, want)
+	}
+
+	err := c.Invalidate("https://example.com/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rv.RemoteBranchAndRevision("https://example.com/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := calls, 2; got != want {
+		t.Errorf("got %v calls after Invalidate, want %v", got, want)
+	}
+}
+
+func TestRemoteCacheVCS(t *testing.T) {
+	c := &RemoteCache{Dir: t.TempDir()} // Zero TTL, so entries are never fresh.
+	var calls int
+	v := c.VCS(fixedRemoteVCS{calls: &calls})
+
+	// Before anything is recorded, fall back to the underlying VCS.
+	branch, err := v.CachedRemoteDefaultBranch("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := branch, "master"; got != want {
+		t.Errorf("got cached remote default branch %q, want %q", got, want)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, _, err := v.RemoteBranchAndRevision("dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := calls, 2; got != want {
+		t.Errorf("got %v calls, want %v", got, want)
+	}
+
+	// Once recorded, the cache entry is preferred.
+	branch, err = v.CachedRemoteDefaultBranch("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := branch, "main"; got != want {
+		t.Errorf("got cached remote default branch %q, want %q", got, want)
+	}
+}
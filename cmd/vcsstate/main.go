@@ -0,0 +1,227 @@
+// vcsstate prints the state of version control system repositories.
+//
+// For each directory given as an argument (or the current directory if none),
+// it finds the repository that contains it, and prints the checked out branch,
+// the local and remote revisions of the default branch, whether the working
+// directory is dirty, whether there's a stash, and whether the local and remote
+// default branches contain each other's latest revision.
+//
+// Usage:
+//
+//	vcsstate [flags] [dir ...]
+//
+// The exit code is 0 if all repositories are clean and in sync with their remote,
+// 1 if any repository has outstanding state (a dirty working directory, a stash,
+// or a local revision that differs from the remote revision), 2 for invalid usage,
+// and 3 if the state of any repository could not be fully determined.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+var (
+	jsonFlag    = flag.Bool("json", false, "Print repository state as JSON objects.")
+	offlineFlag = flag.Bool("offline", false, "Don't query remotes; use the cached remote default branch instead.")
+	timeoutFlag = flag.Duration("timeout", 30*time.Second, "Time limit for querying each repository.")
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: vcsstate [flags] [dir ...]")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	os.Exit(run(flag.Args(), os.Stdout, os.Stderr))
+}
+
+// run prints the state of the repositories that contain dirs to stdout,
+// and returns the exit code.
+func run(dirs []string, stdout, stderr io.Writer) int {
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	exitCode := 0
+	for _, dir := range dirs {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+		r := query(ctx, dir, !*offlineFlag)
+		cancel()
+
+		var err error
+		if *jsonFlag {
+			err = printJSON(stdout, r)
+		} else {
+			err = printText(stdout, r)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 3
+		}
+
+		switch {
+		case r.Error != "":
+			exitCode = 3
+		case r.outstanding() && exitCode == 0:
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// repo is the state of a single repository.
+type repo struct {
+	Dir                 string
+	VCS                 string `json:",omitempty"`
+	Branch              string `json:",omitempty"` // Empty if HEAD is detached.
+	DefaultBranch       string `json:",omitempty"`
+	LocalRevision       string `json:",omitempty"` // Empty if the default branch doesn't exist locally, e.g., it's unborn.
+	RemoteURL           string `json:",omitempty"`
+	RemoteRevision      string `json:",omitempty"` // Empty if offline or there's no remote.
+	Dirty               bool
+	Stash               bool
+	LocalContainsRemote *bool  `json:",omitempty"` // Whether local default branch contains RemoteRevision.
+	RemoteContainsLocal *bool  `json:",omitempty"` // Whether remote default branch contains LocalRevision.
+	Error               string `json:",omitempty"`
+}
+
+// outstanding reports whether r has outstanding state.
+func (r repo) outstanding() bool {
+	return r.Dirty || r.Stash ||
+		r.RemoteRevision != "" && r.LocalRevision != r.RemoteRevision
+}
+
+// query queries the state of the repository rooted at dir.
+// Network is used only if online is true.
+func query(ctx context.Context, dir string, online bool) repo {
+	r := repo{Dir: dir}
+	if err := r.query(ctx, online); err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func (r *repo) query(ctx context.Context, online bool) error {
+	repo, err := vcsstate.Open(r.Dir, nil)
+	if err != nil {
+		return err
+	}
+	r.VCS = string(repo.Kind)
+	v, abs := repo.VCS, repo.Root
+
+	branch, err := v.BranchInfoContext(ctx, abs)
+	if err != nil {
+		return fmt.Errorf("BranchInfo: %v", err)
+	}
+	r.Branch = branch.Name
+	r.RemoteURL, err = v.RemoteURLContext(ctx, abs)
+	if err != nil && err != vcsstate.ErrNoRemote {
+		return fmt.Errorf("RemoteURL: %v", err)
+	}
+
+	// Determine the default branch, and the remote revision if online.
+	// If the remote can't be reached, fall back as if offline.
+	if online && r.RemoteURL != "" {
+		r.DefaultBranch, r.RemoteRevision, err = v.RemoteBranchAndRevisionContext(ctx, abs)
+		if err != nil && err != vcsstate.ErrNoRemote && !errors.As(err, new(vcsstate.OfflineError)) {
+			return fmt.Errorf("RemoteBranchAndRevision: %v", err)
+		}
+	}
+	switch {
+	case r.DefaultBranch != "":
+		// Already determined from the remote.
+	case r.RemoteURL != "":
+		r.DefaultBranch, err = v.CachedRemoteDefaultBranchContext(ctx, abs)
+		if err == nil {
+			break
+		}
+		fallthrough
+	default:
+		r.DefaultBranch, _, err = v.GuessDefaultBranchContext(ctx, abs)
+		if err != nil {
+			return fmt.Errorf("GuessDefaultBranch: %v", err)
+		}
+	}
+
+	r.LocalRevision, err = v.LocalRevisionContext(ctx, abs, r.DefaultBranch)
+	if err != nil && !errors.Is(err, vcsstate.ErrUnknownRevision) {
+		return fmt.Errorf("LocalRevision: %v", err)
+	}
+	status, err := v.StatusContext(ctx, abs)
+	if err != nil {
+		return fmt.Errorf("Status: %v", err)
+	}
+	r.Dirty = status != ""
+	stash, err := v.StashContext(ctx, abs)
+	if err != nil {
+		return fmt.Errorf("Stash: %v", err)
+	}
+	r.Stash = stash != ""
+
+	if r.RemoteRevision == "" || r.LocalRevision == "" {
+		return nil
+	}
+	contains, err := v.ContainsContext(ctx, abs, r.RemoteRevision, r.DefaultBranch)
+	if err != nil {
+		return fmt.Errorf("Contains: %v", err)
+	}
+	r.LocalContainsRemote = &contains
+	remoteContains, err := v.RemoteContainsContext(ctx, abs, r.LocalRevision, r.DefaultBranch)
+	if err != nil {
+		return fmt.Errorf("RemoteContains: %v", err)
+	}
+	r.RemoteContainsLocal = &remoteContains
+	return nil
+}
+
+func printJSON(w io.Writer, r repo) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(r)
+}
+
+func printText(w io.Writer, r repo) error {
+	fmt.Fprintln(w, r.Dir)
+	if r.Branch != "" {
+		fmt.Fprintf(w, "\tbranch:          %s\n", r.Branch)
+	}
+	if r.DefaultBranch != "" {
+		fmt.Fprintf(w, "\tdefault branch:  %s\n", r.DefaultBranch)
+	}
+	if r.LocalRevision != "" {
+		fmt.Fprintf(w, "\tlocal revision:  %s\n", r.LocalRevision)
+	}
+	if r.RemoteURL != "" {
+		fmt.Fprintf(w, "\tremote URL:      %s\n", r.RemoteURL)
+	}
+	if r.RemoteRevision != "" {
+		fmt.Fprintf(w, "\tremote revision: %s\n", r.RemoteRevision)
+	}
+	if r.Error == "" {
+		fmt.Fprintf(w, "\tdirty:           %v\n", r.Dirty)
+		fmt.Fprintf(w, "\tstash:           %v\n", r.Stash)
+	}
+	if r.LocalContainsRemote != nil {
+		fmt.Fprintf(w, "\tlocal contains remote revision: %v\n", *r.LocalContainsRemote)
+	}
+	if r.RemoteContainsLocal != nil {
+		fmt.Fprintf(w, "\tremote contains local revision: %v\n", *r.RemoteContainsLocal)
+	}
+	if r.Error != "" {
+		fmt.Fprintf(w, "\terror: %s\n", r.Error)
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
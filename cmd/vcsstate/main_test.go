@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/shurcooL/vcsstate/vcsstatetest"
+)
+
+func TestRunGit(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	remoteURL := repos.InitRemote("remote")
+	seed := repos.Clone(remoteURL, "seed")
+	repos.Commit(seed, "first")
+	repos.Run(seed, "push", "--quiet", "origin", "HEAD:main")
+
+	inSync := repos.Clone(remoteURL, "in-sync")
+
+	ahead := repos.Clone(remoteURL, "ahead")
+	repos.Commit(ahead, "second")
+
+	// The default branch from the remote doesn't exist locally.
+	missing := repos.Clone(remoteURL, "missing")
+	repos.Run(missing, "checkout", "--quiet", "-b", "feature")
+	repos.Run(missing, "branch", "--quiet", "-D", "main")
+
+	noRemote := repos.Init("no-remote")
+	repos.Commit(noRemote, "first")
+
+	unborn := repos.Init("unborn")
+
+	tests := []struct {
+		name string
+		dir  string
+		want int
+	}{
+		{name: "in sync", dir: inSync, want: 0},
+		{name: "ahead", dir: ahead, want: 1},
+		{name: "missing default branch", dir: missing, want: 1},
+		{name: "no remote", dir: noRemote, want: 0},
+		{name: "unborn", dir: unborn, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			if got := run([]string{tt.dir}, &stdout, io.Discard); got != tt.want {
+				t.Errorf("got exit code %d, want %d; output:\n%s", got, tt.want, stdout.Bytes())
+			}
+		})
+	}
+
+	t.Run("state", func(t *testing.T) {
+		r := query(context.Background(), missing, true)
+		if r.Error != "" || r.DefaultBranch != "main" || r.LocalRevision != "" || r.RemoteRevision == "" {
+			t.Errorf("missing default branch: got %+v", r)
+		}
+		if r.LocalContainsRemote != nil || r.RemoteContainsLocal != nil {
+			t.Errorf("missing default branch: got contains %v and %v, want neither", r.LocalContainsRemote, r.RemoteContainsLocal)
+		}
+		r = query(context.Background(), unborn, true)
+		if r.Error != "" || r.Branch != "main" || r.LocalRevision != "" || r.RemoteURL != "" {
+			t.Errorf("unborn: got %+v", r)
+		}
+	})
+}
+
+func TestRunHgNoRemote(t *testing.T) {
+	repos := vcsstatetest.NewTempHgRepos(t)
+	local := repos.Init("local")
+	revision := repos.Commit(local, "first")
+
+	var stdout bytes.Buffer
+	if got := run([]string{local}, &stdout, io.Discard); got != 0 {
+		t.Errorf("got exit code %d, want 0; output:\n%s", got, stdout.Bytes())
+	}
+	r := query(context.Background(), local, true)
+	if r.Error != "" || r.RemoteURL != "" || r.LocalRevision != revision {
+		t.Errorf("got %+v, want no error, no remote URL and local revision %q", r, revision)
+	}
+}
@@ -0,0 +1,36 @@
+package vcsstate
+
+import "context"
+
+// This file exports internals to tests in package vcsstate_test,
+// which can create repositories with vcsstatetest, unlike tests in package vcsstate.
+
+// ComposeSnapshot gets a snapshot of the repository rooted at dir
+// by calling other methods of v, which must be created by New.
+func ComposeSnapshot(ctx context.Context, v VCS, dir string) (Snapshot, error) {
+	return composeSnapshot(ctx, v.(backgroundVCS).vcsContext, dir)
+}
+
+// GitRepo is a git repository read directly from disk, as used by Options.NativeGit.
+type GitRepo = gitRepo
+
+// ErrGitObjectNotFound is returned by GitRepo.ReadObject for a missing object.
+var ErrGitObjectNotFound = errObjectNotFound
+
+// OpenGitRepo opens the git repository that contains dir.
+func OpenGitRepo(dir string) (*GitRepo, error) { return openGitRepo(dir) }
+
+func (r *gitRepo) Close() error { return r.close() }
+
+// ReadObject returns the type, such as "blob", and content of the object with the given hash.
+func (r *gitRepo) ReadObject(hash string) (typ string, data []byte, err error) {
+	o, err := r.readObject(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	return o.typ.String(), o.data, nil
+}
+
+func (r *gitRepo) IsAncestor(ctx context.Context, ancestor, tip string) (bool, error) {
+	return r.isAncestor(ctx, ancestor, tip)
+}
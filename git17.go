@@ -2,40 +2,60 @@ package vcsstate
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/shurcooL/go/osutil"
 )
 
 // git17 implements git support using git version 1.7+ binary.
-type git17 struct{}
+type git17 struct {
+	git    string // Path to git binary.
+	remote string // Remote name, as in Options.Remote.
+	runner Runner // Runner of git commands, as in Options.Runner.
+}
 
-func (git17) Status(dir string) (string, error) {
-	cmd := exec.Command("git", "status", "--porcelain")
+func (g git17) StatusContext(ctx context.Context, dir string) (string, error) {
+	cmd := exec.Command(g.git, "status", "--porcelain")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
 	if err != nil {
 		return "", err
 	}
 	return string(out), nil
 }
 
-func (git17) Branch(dir string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+func (g git17) WorkingTreeStatusContext(ctx context.Context, dir string) ([]FileStatus, error) {
+	cmd := exec.Command(g.git, "status", "--porcelain", "-z", "--ignored")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitStatus(out)
+}
+
+func (g git17) BranchContext(ctx context.Context, dir string) (string, error) {
+	cmd := exec.Command(g.git, "rev-parse", "--abbrev-ref", "HEAD")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
 	if err != nil {
 		return "", err
 	}
@@ -43,15 +63,59 @@ func (git17) Branch(dir string) (string, error) {
 	return strings.TrimSuffix(string(out), "\n"), nil
 }
 
-func (git17) LocalRevision(dir string, defaultBranch string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", defaultBranch)
+func (g git17) BranchInfoContext(ctx context.Context, dir string) (BranchInfo, error) {
+	cmd := exec.Command(g.git, "symbolic-ref", "--quiet", "HEAD")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	out, err := cmd.Output()
-	if err != nil {
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	var info BranchInfo
+	switch {
+	case err == nil:
+		info.Name = strings.TrimPrefix(strings.TrimSuffix(string(stdout), "\n"), "refs/heads/")
+	case err != nil && ctx.Err() != nil:
+		return BranchInfo{}, err
+	case err != nil && len(stderr) == 0:
+		info.State = Detached // Exit code 1 without output means HEAD is detached.
+	default:
+		return BranchInfo{}, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+
+	cmd = exec.Command(g.git, "rev-parse", "--quiet", "--verify", "HEAD")
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout, stderr, err = dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err == nil:
+		info.Revision = strings.TrimSuffix(string(stdout), "\n")
+	case err != nil && ctx.Err() != nil:
+		return BranchInfo{}, err
+	case err != nil && len(stderr) == 0 && info.State == Named:
+		info.State = Unborn // Exit code 1 without output means HEAD doesn't point to a commit yet.
+	default:
+		return BranchInfo{}, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	return info, nil
+}
+
+func (g git17) LocalRevisionContext(ctx context.Context, dir string, defaultBranch string) (string, error) {
+	// The SHA-256 object format requires git 2.29+, so this only needs to handle SHA-1.
+	cmd := exec.Command(g.git, "rev-parse", defaultBranch)
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	out, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && bytes.Contains(stderr, []byte("unknown revision or path not in the working tree")):
+		return "", fmt.Errorf("%w %q", ErrUnknownRevision, defaultBranch)
+	case err != nil:
 		return "", err
 	}
 	if len(out) < gitRevisionLength {
@@ -60,28 +124,28 @@ func (git17) LocalRevision(dir string, defaultBranch string) (string, error) {
 	return string(out[:gitRevisionLength]), nil
 }
 
-func (git17) Stash(dir string) (string, error) {
-	cmd := exec.Command("git", "stash", "list")
+func (g git17) StashContext(ctx context.Context, dir string) (string, error) {
+	cmd := exec.Command(g.git, "stash", "list")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
 	if err != nil {
 		return "", err
 	}
 	return string(out), nil
 }
 
-func (git17) Contains(dir string, revision string, defaultBranch string) (bool, error) {
-	cmd := exec.Command("git", "branch", "--contains", revision, defaultBranch)
+func (g git17) ContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
+	cmd := exec.Command(g.git, "branch", "--contains", revision, defaultBranch)
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
 	switch {
 	case err == nil:
 		// If this commit is contained, the expected output is exactly "* {defaultBranch}\n"
@@ -91,56 +155,113 @@ func (git17) Contains(dir string, revision string, defaultBranch string) (bool,
 			bytes.Equal(stdout, []byte(fmt.Sprintf("  %s\n", defaultBranch))), nil
 	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: no such commit %s\n", revision))):
 		return false, nil // No such commit error means this commit is not contained.
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: malformed object name %s\n", revision))):
+		return false, nil // Revision is not a known name, so there's no such commit either.
 	default:
 		return false, err
 	}
 }
 
-func (git17) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
-	cmd := exec.Command("git", "branch", "-r", "--contains", revision, "origin/"+defaultBranch)
+func (g git17) RemoteContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
+	remote, err := gitExistingRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return false, err
+	}
+	cmd := exec.Command(g.git, "branch", "-r", "--contains", revision, remote+"/"+defaultBranch)
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
 	switch {
 	case err == nil:
-		// If this commit is contained, the expected output is exactly "  origin/{defaultBranch}\n",
-		// where {defaultBranch} is the value of defaultBranch.
-		return bytes.Equal(stdout, []byte(fmt.Sprintf("  origin/%s\n", defaultBranch))), nil
+		// If this commit is contained, the expected output is exactly "  {remote}/{defaultBranch}\n",
+		// where {remote} and {defaultBranch} are the values of remote and defaultBranch.
+		return bytes.Equal(stdout, []byte(fmt.Sprintf("  %s/%s\n", remote, defaultBranch))), nil
 	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: no such commit %s\n", revision))):
 		return false, nil // No such commit error means this commit is not contained.
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: malformed object name %s\n", revision))):
+		return false, nil // Revision is not a known name, so there's no such commit either.
 	default:
 		return false, err
 	}
 }
 
-func (git17) RemoteURL(dir string) (string, error) {
+func (g git17) AheadBehindContext(ctx context.Context, dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error) {
+	remote, err := gitExistingRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	local, tracking := "refs/heads/"+defaultBranch, "refs/remotes/"+remote+"/"+defaultBranch
+	// --count requires git 1.7.2+, so count the commits instead.
+	cmd := exec.Command(g.git, "rev-list", "--left-right", local+"..."+tracking, "--")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return 0, 0, "", err
+	case err != nil && bytes.Contains(stderr, []byte("fatal: bad revision ")):
+		// The default branch or its remote-tracking branch doesn't exist.
+		return 0, 0, "", fmt.Errorf("%w %q", ErrUnknownRevision, local+"..."+tracking)
+	case err != nil:
+		return 0, 0, "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	ahead, behind = countGitLeftRight(stdout)
+
+	cmd = exec.Command(g.git, "merge-base", local, tracking)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout, stderr, err = dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return 0, 0, "", err
+	case err != nil && len(stderr) == 0:
+		return ahead, behind, "", nil // Exit code 1 without output means there is no merge base.
+	case err != nil:
+		return 0, 0, "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	return ahead, behind, strings.TrimSuffix(string(stdout), "\n"), nil
+}
+
+func (g git17) RemoteURLContext(ctx context.Context, dir string) (string, error) {
 	// We may be on a non-default branch with a different remote set. In order to get consistent results,
-	// we must assume default remote is "origin" and explicitly specify it here. If it doesn't exist,
-	// then we treat that as no remote (even if some other remote exists), because this is a simple
+	// we must use the configured remote ("origin" by default) and explicitly specify it here. If it doesn't
+	// exist, then we treat that as no remote (even if some other remote exists), because this is a simple
 	// and consistent thing to do.
-	// TODO: Once git 2.7 becomes generally available, consider reverting back to `git remote get-url origin`.
-	cmd := exec.Command("git", "remote", "-v")
+	remote, err := gitRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return "", err
+	}
+	// TODO: Once git 2.7 becomes generally available, consider reverting back to `git remote get-url <remote>`.
+	cmd := exec.Command(g.git, "remote", "-v")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
 	if err != nil {
 		return "", err
 	}
-	url, err := parseGit17Remote(out)
+	url, err := parseGit17Remote(out, remote)
 	if err != nil {
 		return "", ErrNoRemote
 	}
 	return url, nil
 }
 
-func (g git17) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
-	cmd := exec.Command("git", "ls-remote", "origin", "HEAD", "refs/heads/*")
+func (g git17) RemoteHeadContext(ctx context.Context, dir string) (RemoteHead, error) {
+	remote, err := gitRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return RemoteHead{}, err
+	}
+	cmd := exec.Command(g.git, "ls-remote", remote, "HEAD", "refs/heads/*")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
@@ -148,27 +269,31 @@ func (g git17) RemoteBranchAndRevision(dir string) (branch string, revision stri
 	env.Set("GIT_SSH_COMMAND", "ssh -o StrictHostKeyChecking=yes") // Default for StrictHostKeyChecking is "ask", which we don't want since this is non-interactive and we prefer to fail than block asking for user input.
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
 	switch {
-	case err != nil && bytes.HasPrefix(stderr, []byte("fatal: 'origin' does not appear to be a git repository\n")):
-		return "", "", ErrNoRemote
+	case err != nil && ctx.Err() != nil:
+		return RemoteHead{}, err
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("fatal: '%s' does not appear to be a git repository\n", remote))):
+		return RemoteHead{}, ErrNoRemote
 	case err != nil:
-		return "", "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+		return RemoteHead{}, remoteError(err, stderr)
 	}
-	_, revision, err = parseGit17LsRemote(stdout)
+	_, revision, err := parseGit17LsRemote(stdout)
 	if err != nil {
-		return "", "", err
+		return RemoteHead{}, err
 	}
-	branch, err = g.remoteBranch(dir)
+	branch, err := g.remoteBranch(ctx, dir, remote)
 	if err != nil {
-		return "", "", err
+		return RemoteHead{}, err
 	}
-	return branch, revision, nil
+	// Without --symref, it's not known whether git remote show got the branch
+	// from the remote's HEAD symbolic ref or guessed it by matching revisions.
+	return RemoteHead{Branch: branch, Revision: revision, Convention: RevisionMatch}, nil
 }
 
 // remoteBranch is needed to reliably get remote default branch until git 2.8 becomes commonly available.
-func (git17) remoteBranch(dir string) (string, error) {
-	cmd := exec.Command("git", "remote", "show", "origin")
+func (g git17) remoteBranch(ctx context.Context, dir string, remote string) (string, error) {
+	cmd := exec.Command(g.git, "remote", "show", remote)
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
@@ -176,9 +301,12 @@ func (git17) remoteBranch(dir string) (string, error) {
 	env.Set("GIT_SSH_COMMAND", "ssh -o StrictHostKeyChecking=yes") // Default for StrictHostKeyChecking is "ask", which we don't want since this is non-interactive and we prefer to fail than block asking for user input.
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
-	if err != nil {
-		return "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil:
+		return "", remoteError(err, stderr)
 	}
 	const s = "\n  HEAD branch: "
 	i := bytes.Index(stdout, []byte(s))
@@ -195,37 +323,191 @@ func (git17) remoteBranch(dir string) (string, error) {
 	return string(stdout[i:nl]), nil
 }
 
-func (git17) CachedRemoteDefaultBranch() (string, error) {
-	// TODO: Apply more effort to actually get a cached remote default branch.
-	//       For now, just fall back to "master", but we can do better than that.
-	return "", fmt.Errorf("not yet implemented for git, fall back to NoRemoteDefaultBranch")
+func (g git17) CachedRemoteDefaultBranchContext(ctx context.Context, dir string) (string, error) {
+	remote, err := gitRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return "", err
+	}
+	// The remote HEAD is cached in refs/remotes/{remote}/HEAD by git clone and git remote set-head.
+	// It's a symbolic ref, so it's never stored in packed-refs.
+	cmd := exec.Command(g.git, "symbolic-ref", "--quiet", "refs/remotes/"+remote+"/HEAD")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && len(stderr) == 0:
+		// Exit code 1 without output means there's no cached remote HEAD.
+		return "", fmt.Errorf("no cached remote HEAD for %s, fall back to NoRemoteDefaultBranch", remote)
+	case err != nil:
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	// E.g., "refs/remotes/origin/main".
+	ref := strings.TrimSuffix(string(stdout), "\n")
+	prefix := "refs/remotes/" + remote + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", fmt.Errorf("cached remote HEAD %q is outside of %q", ref, prefix)
+	}
+	return ref[len(prefix):], nil
+}
+
+func (g git17) GuessDefaultBranchContext(ctx context.Context, dir string) (branch string, source DefaultBranchSource, err error) {
+	// Use init.defaultBranch from repository, user or system configuration, if set.
+	cmd := exec.Command(g.git, "config", "--get", "init.defaultBranch")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	var configured string
+	switch {
+	case err == nil:
+		configured = strings.TrimSuffix(string(stdout), "\n")
+	case err != nil && ctx.Err() != nil:
+		return "", 0, err
+	case err != nil && len(stderr) == 0:
+		// Exit code 1 without output means init.defaultBranch is not set.
+	default:
+		return "", 0, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+
+	// Find which of the configured and conventional branches exist locally.
+	args := []string{"for-each-ref", "--format=%(refname)"}
+	if configured != "" {
+		args = append(args, "refs/heads/"+configured)
+	}
+	for _, b := range conventionalDefaultBranches {
+		args = append(args, "refs/heads/"+b)
+	}
+	cmd = exec.Command(g.git, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout, _, err = dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return "", 0, err
+	}
+	exist := make(map[string]bool)
+	for _, ref := range strings.Split(strings.TrimSuffix(string(stdout), "\n"), "\n") {
+		exist[strings.TrimPrefix(ref, "refs/heads/")] = true
+	}
+
+	branch, source = guessDefaultBranch(configured, exist, g.NoRemoteDefaultBranch())
+	return branch, source, nil
+}
+
+func (g git17) SnapshotContext(ctx context.Context, dir string) (Snapshot, error) {
+	return composeGitSnapshot(ctx, g, g.runner, g.git, dir)
+}
+
+func (g git17) WorktreesContext(ctx context.Context, dir string) ([]Worktree, error) {
+	cmd := exec.Command(g.git, "worktree", "list", "--porcelain")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return nil, err
+	case err != nil && (bytes.Contains(stderr, []byte("is not a git command")) || bytes.HasPrefix(stderr, []byte("usage: git worktree"))):
+		// git worktree list requires git 2.7+. Linked working trees can't be listed
+		// before that, so report only the one containing dir, as the main working tree.
+	case err != nil:
+		return nil, err
+	default:
+		return parseGitWorktreeList(stdout)
+	}
+
+	cmd = exec.Command(g.git, "rev-parse", "--show-toplevel")
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout, _, err = dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return nil, err
+	}
+	w := Worktree{Path: strings.TrimSuffix(string(stdout), "\n"), Main: true}
+	w.Branch, err = g.BranchInfoContext(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return []Worktree{w}, nil
+}
+
+func (g git17) IsMainWorktreeContext(ctx context.Context, dir string) (bool, error) {
+	// The git directory of a linked working tree is in the worktrees directory
+	// of the common one, e.g., ".git/worktrees/<name>", while they're the same
+	// for the main working tree.
+	cmd := exec.Command(g.git, "rev-parse", "--git-dir", "--git-common-dir")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return false, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(lines) != 2 {
+		return false, fmt.Errorf("unexpected rev-parse output: %q", out)
+	}
+	if lines[1] == "--git-common-dir" {
+		// Before git 2.5, --git-common-dir is output back verbatim,
+		// and there are no linked working trees.
+		return true, nil
+	}
+	for i, l := range lines {
+		// Relative paths are relative to dir.
+		if !filepath.IsAbs(l) {
+			lines[i] = filepath.Join(dir, l)
+		}
+	}
+	return filepath.Clean(lines[0]) == filepath.Clean(lines[1]), nil
 }
 
 func (git17) NoRemoteDefaultBranch() string {
 	return "master"
 }
 
-type remoteGit17 struct{}
+type remoteGit17 struct {
+	git    string // Path to git binary.
+	runner Runner // Runner of git commands, as in Options.Runner.
+}
 
-func (remoteGit17) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
-	cmd := exec.Command("git", "ls-remote", remoteURL, "HEAD", "refs/heads/*")
+func (r remoteGit17) RemoteHeadContext(ctx context.Context, remoteURL string) (RemoteHead, error) {
+	cmd := exec.Command(r.git, "ls-remote", remoteURL, "HEAD", "refs/heads/*")
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	env.Set("GIT_ASKPASS", "true")                                 // `true` here is not a boolean value, but a command /bin/true that will make git think it asked for a password, and prevent potential interactive password prompts (opting to return failure exit code instead).
 	env.Set("GIT_SSH_COMMAND", "ssh -o StrictHostKeyChecking=yes") // Default for StrictHostKeyChecking is "ask", which we don't want since this is non-interactive and we prefer to fail than block asking for user input.
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, r.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return RemoteHead{}, err
+	case err != nil:
+		return RemoteHead{}, remoteError(err, stderr)
+	}
+	branch, revision, err := parseGit17LsRemote(stdout)
 	if err != nil {
-		return "", "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+		return RemoteHead{}, err
 	}
-	return parseGit17LsRemote(stdout)
+	return RemoteHead{Branch: branch, Revision: revision, Convention: RevisionMatch}, nil
 }
 
-// parseGit17Remote parses the fetch URL for "origin" remote, if it exists.
-func parseGit17Remote(out []byte) (url string, err error) {
+// parseGit17Remote parses the fetch URL for the named remote, if it exists.
+func parseGit17Remote(out []byte, remote string) (url string, err error) {
 	if len(out) == 0 {
-		return "", errors.New("no origin remote")
+		return "", fmt.Errorf("no %s remote", remote)
 	}
 	lines := strings.Split(string(out[:len(out)-1]), "\n")
 	for _, line := range lines {
@@ -233,7 +515,7 @@ func parseGit17Remote(out []byte) (url string, err error) {
 		nameURLKind := strings.Split(line, "\t")
 		name, urlKind := nameURLKind[0], nameURLKind[1]
 
-		if name != "origin" {
+		if name != remote {
 			continue
 		}
 		if !strings.HasSuffix(urlKind, " (fetch)") {
@@ -242,7 +524,7 @@ func parseGit17Remote(out []byte) (url string, err error) {
 		url := urlKind[:len(urlKind)-len(" (fetch)")]
 		return url, nil
 	}
-	return "", errors.New("no origin remote")
+	return "", fmt.Errorf("no %s remote", remote)
 }
 
 // parseGit17LsRemote parses the branch and revision from output of
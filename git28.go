@@ -2,42 +2,227 @@ package vcsstate
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/shurcooL/go/osutil"
 )
 
-var gitBinaryVersion, gitBinaryError = exec.Command("git", "--version").Output()
+// gitVersions caches the results of probing git binaries run by ExecRunner,
+// keyed by binary path.
+var gitVersions = struct {
+	sync.Mutex
+	m map[string]gitVersion
+}{m: make(map[string]gitVersion)}
+
+// gitVersion is the result of probing a git binary for its version.
+type gitVersion struct {
+	out          []byte // Output of "git --version".
+	major, minor int
+	err          error
+}
+
+// probeGitVersion returns the version of the git binary at path, run by runner.
+// If runner is nil or ExecRunner, successful results are cached, so it runs
+// the binary only until the first success for a given path. Other runners
+// may run git anywhere, so their results aren't cached. Failures aren't cached,
+// since they may be transient. The cache isn't locked while the binary runs,
+// so concurrent first calls may each run it.
+func probeGitVersion(runner Runner, path string) gitVersion {
+	_, isExec := runner.(ExecRunner)
+	cache := runner == nil || isExec
+	if cache {
+		gitVersions.Lock()
+		v, ok := gitVersions.m[path]
+		gitVersions.Unlock()
+		if ok {
+			return v
+		}
+	}
+	var v gitVersion
+	v.out, _, v.err = dividedOutput(context.Background(), runner, exec.Command(path, "--version"))
+	if v.err == nil {
+		_, v.err = fmt.Fscanf(bytes.NewReader(v.out), "git version %d.%d", &v.major, &v.minor)
+	}
+	if cache && v.err == nil {
+		gitVersions.Lock()
+		gitVersions.m[path] = v
+		gitVersions.Unlock()
+	}
+	return v
+}
+
+// gitRemote returns the name of the git remote to use for the repository
+// rooted at dir, given remote as specified in Options.Remote.
+// If the remote can't be determined, ErrNoRemote is returned.
+func gitRemote(ctx context.Context, runner Runner, git string, dir string, remote string) (string, error) {
+	switch remote {
+	case "":
+		return "origin", nil
+	default:
+		return remote, nil
+	case UpstreamRemote:
+		// Use the remote of the checked out branch's configured upstream below.
+	}
+
+	cmd := exec.Command(git, "symbolic-ref", "--quiet", "HEAD")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	stdout, stderr, err := dividedOutput(ctx, runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && len(stderr) == 0:
+		return "", ErrNoRemote // Exit code 1 without output means HEAD is detached, so there's no upstream.
+	case err != nil:
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	branch := strings.TrimPrefix(strings.TrimSuffix(string(stdout), "\n"), "refs/heads/")
+
+	cmd = exec.Command(git, "config", "--get", "branch."+branch+".remote")
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout, stderr, err = dividedOutput(ctx, runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && len(stderr) == 0:
+		return "", ErrNoRemote // Exit code 1 without output means no upstream is configured.
+	case err != nil:
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	remote = strings.TrimSuffix(string(stdout), "\n")
+	if remote == "." {
+		return "", ErrNoRemote // Upstream is a branch in the local repository.
+	}
+	return remote, nil
+}
+
+// gitExistingRemote is like gitRemote, but it also checks that the remote
+// is configured. If it isn't, ErrNoRemote is returned.
+func gitExistingRemote(ctx context.Context, runner Runner, git string, dir string, remote string) (string, error) {
+	remote, err := gitRemote(ctx, runner, git, dir, remote)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(git, "config", "--get", "remote."+remote+".url")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	_, stderr, err := dividedOutput(ctx, runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && len(stderr) == 0:
+		return "", ErrNoRemote // Exit code 1 without output means the remote is not configured.
+	case err != nil:
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	return remote, nil
+}
+
+// composeGitSnapshot is like composeSnapshot, but it also gets the upstream
+// of the checked out branch, and how many commits it's ahead of and behind it,
+// for git versions without git status --porcelain=v2.
+func composeGitSnapshot(ctx context.Context, v vcsContext, runner Runner, git string, dir string) (Snapshot, error) {
+	s, err := composeSnapshot(ctx, v, dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if s.Branch.State != Named {
+		return s, nil
+	}
+
+	// E.g., "refs/remotes/origin/main origin/main\n", or "\n" if there's no upstream.
+	cmd := exec.Command(git, "for-each-ref", "--format=%(upstream) %(upstream:short)", "refs/heads/"+s.Branch.Name)
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	out, _, err := dividedOutput(ctx, runner, cmd)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	upstreamRef, upstream, _ := strings.Cut(strings.TrimSuffix(string(out), "\n"), " ")
+	if upstreamRef == "" {
+		return s, nil // No upstream is configured.
+	}
+	s.Upstream = upstream
+
+	// --count would do the counting, but it requires git 1.7.2+.
+	cmd = exec.Command(git, "rev-list", "--left-right", "refs/heads/"+s.Branch.Name+"..."+upstreamRef, "--")
+	cmd.Dir = dir
+	cmd.Env = env
+
+	out, stderr, err := dividedOutput(ctx, runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return Snapshot{}, err
+	case err != nil && bytes.Contains(stderr, []byte("fatal: bad revision ")):
+		return s, nil // Upstream is gone, e.g., the remote branch was deleted, so there's nothing to compare with.
+	case err != nil:
+		return Snapshot{}, err
+	}
+	s.Ahead, s.Behind = countGitLeftRight(out)
+	return s, nil
+}
 
 // git28 implements git support using git version 2.8+ binary.
-type git28 struct{}
+type git28 struct {
+	git    string // Path to git binary.
+	remote string // Remote name, as in Options.Remote.
+	runner Runner // Runner of git commands, as in Options.Runner.
+}
 
-func (git28) Status(dir string) (string, error) {
-	cmd := exec.Command("git", "status", "--porcelain")
+func (g git28) StatusContext(ctx context.Context, dir string) (string, error) {
+	cmd := exec.Command(g.git, "status", "--porcelain")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
 	if err != nil {
 		return "", err
 	}
 	return string(out), nil
 }
 
-func (git28) Branch(dir string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+func (g git28) WorkingTreeStatusContext(ctx context.Context, dir string) ([]FileStatus, error) {
+	cmd := exec.Command(g.git, "status", "--porcelain", "-z", "--ignored")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitStatus(out)
+}
+
+func (g git28) BranchContext(ctx context.Context, dir string) (string, error) {
+	cmd := exec.Command(g.git, "rev-parse", "--abbrev-ref", "HEAD")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
 	if err != nil {
 		return "", err
 	}
@@ -45,103 +230,211 @@ func (git28) Branch(dir string) (string, error) {
 	return strings.TrimSuffix(string(out), "\n"), nil
 }
 
-// gitRevisionLength is the length of a git revision hash.
+func (g git28) BranchInfoContext(ctx context.Context, dir string) (BranchInfo, error) {
+	cmd := exec.Command(g.git, "symbolic-ref", "--quiet", "HEAD")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	var info BranchInfo
+	switch {
+	case err == nil:
+		info.Name = strings.TrimPrefix(strings.TrimSuffix(string(stdout), "\n"), "refs/heads/")
+	case err != nil && ctx.Err() != nil:
+		return BranchInfo{}, err
+	case err != nil && len(stderr) == 0:
+		info.State = Detached // Exit code 1 without output means HEAD is detached.
+	default:
+		return BranchInfo{}, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+
+	cmd = exec.Command(g.git, "rev-parse", "--quiet", "--verify", "HEAD")
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout, stderr, err = dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err == nil:
+		info.Revision = strings.TrimSuffix(string(stdout), "\n")
+	case err != nil && ctx.Err() != nil:
+		return BranchInfo{}, err
+	case err != nil && len(stderr) == 0 && info.State == Named:
+		info.State = Unborn // Exit code 1 without output means HEAD doesn't point to a commit yet.
+	default:
+		return BranchInfo{}, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	return info, nil
+}
+
+// gitRevisionLength is the length of a git revision hash
+// in a repository that uses the SHA-1 object format.
 const gitRevisionLength = 40
 
-func (git28) LocalRevision(dir string, defaultBranch string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", defaultBranch)
+// gitSHA256RevisionLength is the length of a git revision hash
+// in a repository that uses the SHA-256 object format.
+const gitSHA256RevisionLength = 64
+
+func (g git28) LocalRevisionContext(ctx context.Context, dir string, defaultBranch string) (string, error) {
+	// --show-object-format is needed to know the revision hash length. It requires git 2.29+,
+	// but older versions output it back verbatim, and they only support the SHA-1 object format.
+	cmd := exec.Command(g.git, "rev-parse", "--show-object-format", defaultBranch)
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	out, err := cmd.Output()
-	if err != nil {
+	out, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && bytes.Contains(stderr, []byte("unknown revision or path not in the working tree")):
+		return "", fmt.Errorf("%w %q", ErrUnknownRevision, defaultBranch)
+	case err != nil:
 		return "", err
 	}
-	if len(out) < gitRevisionLength {
-		return "", fmt.Errorf("output length %v is shorter than %v", len(out), gitRevisionLength)
-	}
-	return string(out[:gitRevisionLength]), nil
+	return parseGit28RevParse(out)
 }
 
-func (git28) Stash(dir string) (string, error) {
-	cmd := exec.Command("git", "stash", "list")
+func (g git28) StashContext(ctx context.Context, dir string) (string, error) {
+	cmd := exec.Command(g.git, "stash", "list")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
 	if err != nil {
 		return "", err
 	}
 	return string(out), nil
 }
 
-func (git28) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+func (g git28) ContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
 	// --format=contains is just an arbitrary constant string that we look for in the output.
-	cmd := exec.Command("git", "for-each-ref", "--format=contains", "--count=1", "--contains", revision, "refs/heads/"+defaultBranch)
+	cmd := exec.Command(g.git, "for-each-ref", "--format=contains", "--count=1", "--contains", revision, "refs/heads/"+defaultBranch)
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
 	switch {
 	case err == nil:
 		// If this commit is contained, the expected output is exactly "contains\n".
 		return bytes.Equal(stdout, []byte("contains\n")), nil
 	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: no such commit %s\n", revision))):
 		return false, nil // No such commit error means this commit is not contained.
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: malformed object name %s\n", revision))):
+		return false, nil // Revision is not a known name, so there's no such commit either.
 	default:
 		return false, err
 	}
 }
 
-func (git28) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+func (g git28) RemoteContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
+	remote, err := gitExistingRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return false, err
+	}
 	// --format=contains is just an arbitrary constant string that we look for in the output.
-	cmd := exec.Command("git", "for-each-ref", "--format=contains", "--count=1", "--contains", revision, "refs/remotes/origin/"+defaultBranch)
+	cmd := exec.Command(g.git, "for-each-ref", "--format=contains", "--count=1", "--contains", revision, "refs/remotes/"+remote+"/"+defaultBranch)
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
 	switch {
 	case err == nil:
 		// If this commit is contained, the expected output is exactly "contains\n".
 		return bytes.Equal(stdout, []byte("contains\n")), nil
 	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: no such commit %s\n", revision))):
 		return false, nil // No such commit error means this commit is not contained.
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("error: malformed object name %s\n", revision))):
+		return false, nil // Revision is not a known name, so there's no such commit either.
 	default:
 		return false, err
 	}
 }
 
-func (git28) RemoteURL(dir string) (string, error) {
+func (g git28) AheadBehindContext(ctx context.Context, dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error) {
+	remote, err := gitExistingRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	local, tracking := "refs/heads/"+defaultBranch, "refs/remotes/"+remote+"/"+defaultBranch
+	cmd := exec.Command(g.git, "rev-list", "--left-right", "--count", local+"..."+tracking)
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return 0, 0, "", err
+	case err != nil && bytes.Contains(stderr, []byte("unknown revision or path not in the working tree")):
+		// The default branch or its remote-tracking branch doesn't exist.
+		return 0, 0, "", fmt.Errorf("%w %q", ErrUnknownRevision, local+"..."+tracking)
+	case err != nil:
+		return 0, 0, "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	ahead, behind, err = parseGitLeftRightCount(stdout)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	cmd = exec.Command(g.git, "merge-base", local, tracking)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout, stderr, err = dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return 0, 0, "", err
+	case err != nil && len(stderr) == 0:
+		return ahead, behind, "", nil // Exit code 1 without output means there is no merge base.
+	case err != nil:
+		return 0, 0, "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	return ahead, behind, strings.TrimSuffix(string(stdout), "\n"), nil
+}
+
+func (g git28) RemoteURLContext(ctx context.Context, dir string) (string, error) {
 	// We may be on a non-default branch with a different remote set. In order to get consistent results,
-	// we must assume default remote is "origin" and explicitly specify it here. If it doesn't exist,
-	// then we treat that as no remote (even if some other remote exists), because this is a simple
+	// we must use the configured remote ("origin" by default) and explicitly specify it here. If it doesn't
+	// exist, then we treat that as no remote (even if some other remote exists), because this is a simple
 	// and consistent thing to do.
-	cmd := exec.Command("git", "remote", "get-url", "origin")
+	remote, err := gitRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(g.git, "remote", "get-url", remote)
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
 	switch {
-	case err != nil && bytes.Equal(stderr, []byte("fatal: No such remote 'origin'\n")):
+	case err != nil && bytes.Equal(stderr, []byte(fmt.Sprintf("fatal: No such remote '%s'\n", remote))):
 		return "", ErrNoRemote
+	case err != nil && bytes.Equal(stderr, []byte(fmt.Sprintf("error: No such remote '%s'\n", remote))):
+		return "", ErrNoRemote // Since git 2.30, it's reported as an error rather than fatal.
 	case err != nil:
 		return "", err
 	}
 	return strings.TrimSuffix(string(stdout), "\n"), nil
 }
 
-func (g git28) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
-	cmd := exec.Command("git", "ls-remote", "--symref", "origin", "HEAD", "refs/heads/*")
+func (g git28) RemoteHeadContext(ctx context.Context, dir string) (RemoteHead, error) {
+	remote, err := gitRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return RemoteHead{}, err
+	}
+	cmd := exec.Command(g.git, "ls-remote", "--symref", remote, "HEAD", "refs/heads/*")
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
@@ -152,38 +445,40 @@ func (g git28) RemoteBranchAndRevision(dir string) (branch string, revision stri
 	env.Set("GIT_SSH_COMMAND", "ssh -o StrictHostKeyChecking=yes") // Default for StrictHostKeyChecking is "ask", which we don't want since this is non-interactive and we prefer to fail than block asking for user input.
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
 	switch {
-	case err != nil && bytes.HasPrefix(stderr, []byte("fatal: 'origin' does not appear to be a git repository\n")):
-		return "", "", ErrNoRemote
-	case err != nil && bytes.HasPrefix(stderr, []byte("remote: Repository not found.\n")):
-		return "", "", NotFoundError{Err: fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))}
-	// TODO: Consider detecting connectivity errors specifically via "fatal: unable to access " prefix:
-	//
-	//       	(done with wi-fi turned off)
-	//       	gostatus $ git ls-remote --symref origin HEAD refs/heads/*
-	//       	fatal: unable to access 'https://github.com/shurcooL/gostatus/': Could not resolve host: github.com
+	case err != nil && ctx.Err() != nil:
+		return RemoteHead{}, err
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("fatal: '%s' does not appear to be a git repository\n", remote))):
+		return RemoteHead{}, ErrNoRemote
 	case err != nil:
-		return "", "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+		// E.g., with wi-fi turned off:
+		//
+		// 	gostatus $ git ls-remote --symref origin HEAD refs/heads/*
+		// 	fatal: unable to access 'https://github.com/shurcooL/gostatus/': Could not resolve host: github.com
+		return RemoteHead{}, remoteError(err, stderr)
 	}
-	branch, revision, err = parseGit28LsRemote(stdout)
+	head := RemoteHead{Convention: SymbolicRef}
+	head.Branch, head.Revision, err = parseGit28LsRemote(stdout)
 	switch {
 	case err == errBranchNotFound:
 		// Some git servers doesn't support --symref option of ls-remote, so we need to fall back.
-		branch, err = g.remoteBranch(dir)
+		// Without it, git remote show can only guess the branch by matching revisions.
+		head.Convention = RevisionMatch
+		head.Branch, err = g.remoteBranch(ctx, dir, remote)
 		if err != nil {
-			return "", "", err
+			return RemoteHead{}, err
 		}
 	case err != nil:
-		return "", "", err
+		return RemoteHead{}, err
 	}
-	return branch, revision, nil
+	return head, nil
 }
 
 // remoteBranch is still needed to reliably get remote default branch
 // when git server doesn't support --symref option of ls-remote.
-func (git28) remoteBranch(dir string) (string, error) {
-	cmd := exec.Command("git", "remote", "show", "origin")
+func (g git28) remoteBranch(ctx context.Context, dir string, remote string) (string, error) {
+	cmd := exec.Command(g.git, "remote", "show", remote)
 	cmd.Dir = dir
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
@@ -191,9 +486,12 @@ func (git28) remoteBranch(dir string) (string, error) {
 	env.Set("GIT_SSH_COMMAND", "ssh -o StrictHostKeyChecking=yes") // Default for StrictHostKeyChecking is "ask", which we don't want since this is non-interactive and we prefer to fail than block asking for user input.
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
-	if err != nil {
-		return "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil:
+		return "", remoteError(err, stderr)
 	}
 	const s = "\n  HEAD branch: "
 	i := bytes.Index(stdout, []byte(s))
@@ -210,47 +508,286 @@ func (git28) remoteBranch(dir string) (string, error) {
 	return string(stdout[i:nl]), nil
 }
 
-func (git28) CachedRemoteDefaultBranch() (string, error) {
-	// TODO: Apply more effort to actually get a cached remote default branch.
-	//       For now, just fall back to "master", but we can do better than that.
-	return "", fmt.Errorf("not yet implemented for git, fall back to NoRemoteDefaultBranch")
+func (g git28) CachedRemoteDefaultBranchContext(ctx context.Context, dir string) (string, error) {
+	remote, err := gitRemote(ctx, g.runner, g.git, dir, g.remote)
+	if err != nil {
+		return "", err
+	}
+	// The remote HEAD is cached in refs/remotes/{remote}/HEAD by git clone and git remote set-head.
+	// It's a symbolic ref, so it's never stored in packed-refs.
+	cmd := exec.Command(g.git, "symbolic-ref", "--quiet", "refs/remotes/"+remote+"/HEAD")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && len(stderr) == 0:
+		// Exit code 1 without output means there's no cached remote HEAD.
+		return "", fmt.Errorf("no cached remote HEAD for %s, fall back to NoRemoteDefaultBranch", remote)
+	case err != nil:
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+	// E.g., "refs/remotes/origin/main".
+	ref := strings.TrimSuffix(string(stdout), "\n")
+	prefix := "refs/remotes/" + remote + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", fmt.Errorf("cached remote HEAD %q is outside of %q", ref, prefix)
+	}
+	return ref[len(prefix):], nil
+}
+
+func (g git28) GuessDefaultBranchContext(ctx context.Context, dir string) (branch string, source DefaultBranchSource, err error) {
+	// Use init.defaultBranch from repository, user or system configuration, if set.
+	cmd := exec.Command(g.git, "config", "--get", "init.defaultBranch")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+	var configured string
+	switch {
+	case err == nil:
+		configured = strings.TrimSuffix(string(stdout), "\n")
+	case err != nil && ctx.Err() != nil:
+		return "", 0, err
+	case err != nil && len(stderr) == 0:
+		// Exit code 1 without output means init.defaultBranch is not set.
+	default:
+		return "", 0, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	}
+
+	// Find which of the configured and conventional branches exist locally.
+	args := []string{"for-each-ref", "--format=%(refname)"}
+	if configured != "" {
+		args = append(args, "refs/heads/"+configured)
+	}
+	for _, b := range conventionalDefaultBranches {
+		args = append(args, "refs/heads/"+b)
+	}
+	cmd = exec.Command(g.git, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	stdout, _, err = dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return "", 0, err
+	}
+	exist := make(map[string]bool)
+	for _, ref := range strings.Split(strings.TrimSuffix(string(stdout), "\n"), "\n") {
+		exist[strings.TrimPrefix(ref, "refs/heads/")] = true
+	}
+
+	branch, source = guessDefaultBranch(configured, exist, g.NoRemoteDefaultBranch())
+	return branch, source, nil
+}
+
+func (g git28) SnapshotContext(ctx context.Context, dir string) (Snapshot, error) {
+	// Porcelain v2 status requires git 2.11+, and %(upstream:remotename) requires git 2.16+.
+	if v := probeGitVersion(g.runner, g.git); v.err != nil || v.major == 2 && v.minor < 16 {
+		return composeGitSnapshot(ctx, g, g.runner, g.git, dir)
+	}
+
+	cmd := exec.Command(g.git, "status", "--porcelain=v2", "--branch", "-z")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	status, err := parseGitStatusV2(out)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	s := Snapshot{
+		Upstream: status.upstream,
+		Ahead:    status.ahead,
+		Behind:   status.behind,
+		Files:    status.files,
+	}
+	switch {
+	case status.head == "(detached)":
+		s.Branch = BranchInfo{State: Detached, Revision: status.oid}
+	case status.oid == "(initial)":
+		s.Branch = BranchInfo{Name: status.head, State: Unborn}
+	default:
+		s.Branch = BranchInfo{Name: status.head, Revision: status.oid}
+	}
+
+	// Get branches, remote-tracking branches and the stash in one go.
+	// E.g., "refs/remotes/origin/HEAD\x00<hash>\x00refs/remotes/origin/main\x00\n".
+	cmd = exec.Command(g.git, "for-each-ref", "--format=%(refname)%00%(objectname)%00%(symref)%00%(upstream:remotename)", "refs/heads", "refs/remotes", "refs/stash")
+	cmd.Dir = dir
+	cmd.Env = env
+
+	out, _, err = dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	type ref struct{ hash, symref, remote string }
+	refs := make(map[string]ref)
+	heads := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 4 {
+			continue // No refs.
+		}
+		refs[fields[0]] = ref{hash: fields[1], symref: fields[2], remote: fields[3]}
+		if strings.HasPrefix(fields[0], "refs/heads/") {
+			heads[strings.TrimPrefix(fields[0], "refs/heads/")] = true
+		}
+	}
+	_, s.Stash = refs["refs/stash"]
+
+	// Use the cached remote HEAD, like CachedRemoteDefaultBranch does.
+	remote := g.remote
+	switch {
+	case remote == "":
+		remote = "origin"
+	case remote == UpstreamRemote && s.Branch.State == Named:
+		remote = refs["refs/heads/"+s.Branch.Name].remote
+	case remote == UpstreamRemote && s.Branch.State == Unborn:
+		// An unborn branch isn't listed by for-each-ref, so look up its upstream remote.
+		remote, err = gitRemote(ctx, g.runner, g.git, dir, g.remote)
+		if err != nil && err != ErrNoRemote {
+			return Snapshot{}, err
+		}
+	case remote == UpstreamRemote:
+		remote = "" // HEAD is detached, so there's no upstream.
+	}
+	prefix := "refs/remotes/" + remote + "/"
+	if target := refs[prefix+"HEAD"].symref; remote != "" && remote != "." && strings.HasPrefix(target, prefix) {
+		s.DefaultBranch = target[len(prefix):]
+	} else {
+		// Fall back to guessing, like GuessDefaultBranch does.
+		cmd = exec.Command(g.git, "config", "--get", "init.defaultBranch")
+		cmd.Dir = dir
+		cmd.Env = env
+
+		stdout, stderr, err := dividedOutput(ctx, g.runner, cmd)
+		var configured string
+		switch {
+		case err == nil:
+			configured = strings.TrimSuffix(string(stdout), "\n")
+		case err != nil && ctx.Err() != nil:
+			return Snapshot{}, err
+		case err != nil && len(stderr) == 0:
+			// Exit code 1 without output means init.defaultBranch is not set.
+		default:
+			return Snapshot{}, fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+		}
+		s.DefaultBranch, _ = guessDefaultBranch(configured, heads, g.NoRemoteDefaultBranch())
+	}
+	s.LocalRevision = refs["refs/heads/"+s.DefaultBranch].hash
+	return s, nil
+}
+
+func (g git28) WorktreesContext(ctx context.Context, dir string) ([]Worktree, error) {
+	cmd := exec.Command(g.git, "worktree", "list", "--porcelain")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitWorktreeList(out)
+}
+
+func (g git28) IsMainWorktreeContext(ctx context.Context, dir string) (bool, error) {
+	// The git directory of a linked working tree is in the worktrees directory
+	// of the common one, e.g., ".git/worktrees/<name>", while they're the same
+	// for the main working tree.
+	cmd := exec.Command(g.git, "rev-parse", "--git-dir", "--git-common-dir")
+	cmd.Dir = dir
+	env := osutil.Environ(os.Environ())
+	env.Set("LANG", "en_US.UTF-8")
+	cmd.Env = env
+
+	out, _, err := dividedOutput(ctx, g.runner, cmd)
+	if err != nil {
+		return false, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(lines) != 2 {
+		return false, fmt.Errorf("unexpected rev-parse output: %q", out)
+	}
+	for i, l := range lines {
+		// Relative paths are relative to dir.
+		if !filepath.IsAbs(l) {
+			lines[i] = filepath.Join(dir, l)
+		}
+	}
+	return filepath.Clean(lines[0]) == filepath.Clean(lines[1]), nil
 }
 
 func (git28) NoRemoteDefaultBranch() string {
 	return "master"
 }
 
-type remoteGit28 struct{}
+type remoteGit28 struct {
+	git    string // Path to git binary.
+	runner Runner // Runner of git commands, as in Options.Runner.
+}
 
-func (remoteGit28) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
-	cmd := exec.Command("git", "ls-remote", "--symref", remoteURL, "HEAD", "refs/heads/*")
+func (r remoteGit28) RemoteHeadContext(ctx context.Context, remoteURL string) (RemoteHead, error) {
+	cmd := exec.Command(r.git, "ls-remote", "--symref", remoteURL, "HEAD", "refs/heads/*")
 	env := osutil.Environ(os.Environ())
 	env.Set("LANG", "en_US.UTF-8")
 	env.Set("GIT_ASKPASS", "true")                                 // `true` here is not a boolean value, but a command /bin/true that will make git think it asked for a password, and prevent potential interactive password prompts (opting to return failure exit code instead).
 	env.Set("GIT_SSH_COMMAND", "ssh -o StrictHostKeyChecking=yes") // Default for StrictHostKeyChecking is "ask", which we don't want since this is non-interactive and we prefer to fail than block asking for user input.
 	cmd.Env = env
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, r.runner, cmd)
 	switch {
-	case err != nil && bytes.HasPrefix(stderr, []byte("remote: Repository not found.\n")):
-		return "", "", NotFoundError{Err: fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))}
-	// TODO: Consider detecting connectivity errors specifically via "fatal: unable to access " prefix.
+	case err != nil && ctx.Err() != nil:
+		return RemoteHead{}, err
 	case err != nil:
-		return "", "", fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+		return RemoteHead{}, remoteError(err, stderr)
 	}
-	branch, revision, err = parseGit28LsRemote(stdout)
+	head := RemoteHead{Convention: SymbolicRef}
+	head.Branch, head.Revision, err = parseGit28LsRemote(stdout)
 	switch {
 	case err == errBranchNotFound:
 		// Some git servers doesn't support --symref option of ls-remote, so we need to fall back.
 		// Use guessBranch for now, because it's the best option I can think of at this time.
-		branch, err = guessBranch(stdout, revision)
+		head.Convention = RevisionMatch
+		head.Branch, err = guessBranch(stdout, head.Revision)
 		if err != nil {
-			return "", "", err
+			return RemoteHead{}, err
 		}
 	case err != nil:
-		return "", "", err
+		return RemoteHead{}, err
+	}
+	return head, nil
+}
+
+// parseGit28RevParse parses the revision from output of
+// rev-parse --show-object-format <revision>.
+func parseGit28RevParse(out []byte) (revision string, err error) {
+	// E.g., "sha256\n1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5\n".
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(lines) != 2 {
+		return "", fmt.Errorf("unexpected rev-parse output %q", out)
+	}
+	format, revision := lines[0], lines[1]
+	want := gitRevisionLength
+	if format == "sha256" {
+		want = gitSHA256RevisionLength
+	}
+	if len(revision) != want {
+		return "", fmt.Errorf("revision length %v is not %v", len(revision), want)
 	}
-	return branch, revision, nil
+	return revision, nil
 }
 
 // parseGit28LsRemote parses the branch and revision from output of
@@ -300,6 +837,7 @@ func guessBranch(out []byte, revision string) (branch string, err error) {
 	if len(out) == 0 {
 		return "", errors.New("empty ls-remote output")
 	}
+	var branches []string
 	lines := strings.Split(string(out[:len(out)-1]), "\n")
 	for _, line := range lines {
 		// E.g., "7cafcd837844e784b526369c9bce262804aebc60	refs/heads/main".
@@ -308,16 +846,55 @@ func guessBranch(out []byte, revision string) (branch string, err error) {
 		if rev != revision || ref == "HEAD" {
 			continue
 		}
-		// HACK: There may be more than one branch that matches; prefer "master" over all
-		//       others, but otherwise no choice but to pick a random one, since there does
-		//       not seem to be a way of finding it exactly (I'm happy to be proven wrong though).
-		//       Unfortunately some git servers still don't support --symref option.
-		if branch != "master" {
-			branch = ref[len("refs/heads/"):]
-		}
+		branches = append(branches, ref[len("refs/heads/"):])
 	}
-	if branch == "" {
+	if len(branches) == 0 {
 		return "", errBranchNotFound
 	}
-	return branch, nil
+	return pickBranch(branches), nil
+}
+
+// pickBranch picks the default branch among branches whose latest revision
+// matches the remote HEAD, for when the target of HEAD isn't known.
+// Unfortunately some git servers still don't support --symref option.
+//
+// HACK: There may be more than one branch that matches; prefer "master" over all
+// others, but otherwise there's no way of finding it exactly, so to be deterministic,
+// pick the last one in sorted order.
+func pickBranch(branches []string) string {
+	branch := ""
+	for _, b := range branches {
+		if b == "master" {
+			return b
+		}
+		if b > branch {
+			branch = b
+		}
+	}
+	return branch
+}
+
+// parseGitLeftRightCount parses the output of rev-list --left-right --count,
+// e.g., "3\t5\n".
+func parseGitLeftRightCount(out []byte) (left int, right int, err error) {
+	_, err = fmt.Sscanf(string(out), "%d\t%d\n", &left, &right)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q: %v", out, err)
+	}
+	return left, right, nil
+}
+
+// countGitLeftRight counts the commits in the output of rev-list --left-right,
+// where each commit is printed as "<hash" if it's only on the left side,
+// or ">hash" if it's only on the right side.
+func countGitLeftRight(out []byte) (left int, right int) {
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "<"):
+			left++
+		case strings.HasPrefix(line, ">"):
+			right++
+		}
+	}
+	return left, right
 }
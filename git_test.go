@@ -1,6 +1,7 @@
 package vcsstate
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"testing"
@@ -22,6 +23,41 @@ fbbaff1827317122a8a0e1b24de25df8417ce87b	refs/heads/master
 			revision:   "fbbaff1827317122a8a0e1b24de25df8417ce87b",
 			wantBranch: "master",
 		},
+		// SHA-256 object format.
+		{
+			in: []byte(`1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5	HEAD
+1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5	refs/heads/main
+`),
+			revision:   "1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5",
+			wantBranch: "main",
+		},
+		// More than one branch matches; "master" is preferred.
+		{
+			in: []byte(`fbbaff1827317122a8a0e1b24de25df8417ce87b	HEAD
+fbbaff1827317122a8a0e1b24de25df8417ce87b	refs/heads/develop
+fbbaff1827317122a8a0e1b24de25df8417ce87b	refs/heads/master
+fbbaff1827317122a8a0e1b24de25df8417ce87b	refs/heads/release
+`),
+			revision:   "fbbaff1827317122a8a0e1b24de25df8417ce87b",
+			wantBranch: "master",
+		},
+		// More than one branch matches, none of them "master"; the last one wins.
+		{
+			in: []byte(`fbbaff1827317122a8a0e1b24de25df8417ce87b	HEAD
+fbbaff1827317122a8a0e1b24de25df8417ce87b	refs/heads/develop
+0000000000000000000000000000000000000001	refs/heads/other
+fbbaff1827317122a8a0e1b24de25df8417ce87b	refs/heads/release
+`),
+			revision:   "fbbaff1827317122a8a0e1b24de25df8417ce87b",
+			wantBranch: "release",
+		},
+		{
+			in: []byte(`fbbaff1827317122a8a0e1b24de25df8417ce87b	HEAD
+0000000000000000000000000000000000000001	refs/heads/other
+`),
+			revision: "fbbaff1827317122a8a0e1b24de25df8417ce87b",
+			wantErr:  errBranchNotFound,
+		},
 	}
 
 	for _, test := range tests {
@@ -42,6 +78,7 @@ fbbaff1827317122a8a0e1b24de25df8417ce87b	refs/heads/master
 func TestParseGit17Remote(t *testing.T) {
 	tests := []struct {
 		in      []byte
+		remote  string
 		want    string
 		wantErr error
 	}{
@@ -53,10 +90,12 @@ origin	https://github.com/shurcooL/vcsstate (push)
 somebody	https://github.com/somebody/vcsstate (fetch)
 somebody	https://github.com/somebody/vcsstate (push)
 `),
-			want: "https://github.com/shurcooL/vcsstate",
+			remote: "origin",
+			want:   "https://github.com/shurcooL/vcsstate",
 		},
 		{
 			in:      []byte(""),
+			remote:  "origin",
 			wantErr: errors.New("no origin remote"),
 		},
 		// Only accept "origin" remote, even if others exist.
@@ -64,12 +103,23 @@ somebody	https://github.com/somebody/vcsstate (push)
 			in: []byte(`fork	https://github.com/foobar/vcsstate (fetch)
 fork	https://github.com/foobar/vcsstate (push)
 `),
+			remote:  "origin",
 			wantErr: errors.New("no origin remote"),
 		},
+		// Accept a custom remote name.
+		{
+			in: []byte(`origin	https://github.com/foobar/vcsstate (fetch)
+origin	https://github.com/foobar/vcsstate (push)
+upstream	https://github.com/shurcooL/vcsstate (fetch)
+upstream	https://github.com/shurcooL/vcsstate (push)
+`),
+			remote: "upstream",
+			want:   "https://github.com/shurcooL/vcsstate",
+		},
 	}
 
 	for _, test := range tests {
-		url, err := parseGit17Remote(test.in)
+		url, err := parseGit17Remote(test.in, test.remote)
 		if got, want := err, test.wantErr; !reflect.DeepEqual(got, want) {
 			t.Errorf("got %#v, want %#v", got, want)
 		}
@@ -114,6 +164,14 @@ f93697607c2406ba00b57fe418f4101b4e447eb8	refs/heads/numbers
 			wantBranch:   "master",
 			wantRevision: "f0aeabca5a127c4078abb8c8d64298b147264b55",
 		},
+		// SHA-256 object format.
+		{
+			in: []byte(`1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5	HEAD
+1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5	refs/heads/main
+`),
+			wantBranch:   "main",
+			wantRevision: "1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5",
+		},
 	}
 
 	for _, test := range tests {
@@ -133,3 +191,140 @@ f93697607c2406ba00b57fe418f4101b4e447eb8	refs/heads/numbers
 		}
 	}
 }
+
+func TestGit28ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := git28{git: "git"}.StatusContext(ctx, ".")
+	if got, want := err, context.Canceled; got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	_, err = remoteGit28{git: "git"}.RemoteHeadContext(ctx, "https://example.com/repo")
+	if got, want := err, context.Canceled; got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestParseGitLeftRightCount(t *testing.T) {
+	left, right, err := parseGitLeftRightCount([]byte("3\t5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := left, 3; got != want {
+		t.Errorf("got left %v, want %v", got, want)
+	}
+	if got, want := right, 5; got != want {
+		t.Errorf("got right %v, want %v", got, want)
+	}
+
+	_, _, err = parseGitLeftRightCount([]byte(""))
+	if err == nil {
+		t.Error("got nil error for empty output, want non-nil")
+	}
+}
+
+func TestCountGitLeftRight(t *testing.T) {
+	left, right := countGitLeftRight([]byte("<1111111111111111111111111111111111111111\n>2222222222222222222222222222222222222222\n<3333333333333333333333333333333333333333\n"))
+	if left != 2 || right != 1 {
+		t.Errorf("got %v, %v, want 2, 1", left, right)
+	}
+	if left, right := countGitLeftRight(nil); left != 0 || right != 0 {
+		t.Errorf("got %v, %v for empty output, want 0, 0", left, right)
+	}
+}
+
+func TestParseGit28LsRemote(t *testing.T) {
+	tests := []struct {
+		in           []byte
+		wantBranch   string
+		wantRevision string
+		wantErr      error
+	}{
+		{
+			in: []byte(`ref: refs/heads/main	HEAD
+7cafcd837844e784b526369c9bce262804aebc60	HEAD
+0a50dc0e5a012dbf22f1289471dc52bc0fe44e9a	refs/heads/cb
+7cafcd837844e784b526369c9bce262804aebc60	refs/heads/main
+`),
+			wantBranch:   "main",
+			wantRevision: "7cafcd837844e784b526369c9bce262804aebc60",
+		},
+		// SHA-256 object format.
+		{
+			in: []byte(`ref: refs/heads/master	HEAD
+1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5	HEAD
+1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5	refs/heads/master
+`),
+			wantBranch:   "master",
+			wantRevision: "1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5",
+		},
+		// Git server doesn't support --symref option.
+		{
+			in: []byte(`7cafcd837844e784b526369c9bce262804aebc60	HEAD
+7cafcd837844e784b526369c9bce262804aebc60	refs/heads/main
+`),
+			wantErr: errBranchNotFound,
+		},
+		{
+			in:      []byte(""),
+			wantErr: errors.New("empty ls-remote output"),
+		},
+	}
+
+	for _, test := range tests {
+		branch, revision, err := parseGit28LsRemote(test.in)
+		if got, want := err, test.wantErr; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+		if test.wantErr != nil {
+			continue
+		}
+
+		if got, want := branch, test.wantBranch; got != want {
+			t.Errorf("got branch %q, want %q", got, want)
+		}
+		if got, want := revision, test.wantRevision; got != want {
+			t.Errorf("got revision %q, want %q", got, want)
+		}
+	}
+}
+
+func TestParseGit28RevParse(t *testing.T) {
+	tests := []struct {
+		in      []byte
+		want    string
+		wantErr error
+	}{
+		{
+			in:   []byte("sha1\n25f6e0032aedd12c802cadab6c64ec626f62af49\n"),
+			want: "25f6e0032aedd12c802cadab6c64ec626f62af49",
+		},
+		{
+			in:   []byte("sha256\n1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5\n"),
+			want: "1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5",
+		},
+		// Older git versions output unknown options back verbatim.
+		{
+			in:   []byte("--show-object-format\n25f6e0032aedd12c802cadab6c64ec626f62af49\n"),
+			want: "25f6e0032aedd12c802cadab6c64ec626f62af49",
+		},
+		{
+			in:      []byte("sha1\n1c88a4c11403e62d68d5e71be8e11d065ca79cc369d2854c1e6d7675911dddc5\n"),
+			wantErr: errors.New("revision length 64 is not 40"),
+		},
+	}
+
+	for _, test := range tests {
+		revision, err := parseGit28RevParse(test.in)
+		if got, want := err, test.wantErr; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+		if test.wantErr != nil {
+			continue
+		}
+
+		if got, want := revision, test.want; got != want {
+			t.Errorf("got revision %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package vcsstate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// remoteGitHTTP is a git implementation of RemoteVCS that speaks the smart HTTP
+// protocol directly, without using the git binary. It supports protocol version 2,
+// and falls back to version 0 for servers that don't support it.
+// Only http and https remote URLs are supported.
+type remoteGitHTTP struct {
+	client *http.Client // If nil, http.DefaultClient is used.
+}
+
+func (r remoteGitHTTP) RemoteHeadContext(ctx context.Context, remoteURL string) (RemoteHead, error) {
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return RemoteHead{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return RemoteHead{}, fmt.Errorf("remote URL %q is not supported, only http and https remote URLs are", remoteURL)
+	}
+	refs, err := r.lsRefs(ctx, strings.TrimSuffix(remoteURL, "/"))
+	if err != nil {
+		return RemoteHead{}, err
+	}
+	return gitRemoteHeadFromRefs(refs)
+}
+
+// gitRemoteRef is a ref advertised by a git server.
+type gitRemoteRef struct {
+	Name   string // E.g., "HEAD" or "refs/heads/main".
+	Hash   string
+	Target string // Target of symbolic ref, e.g., "refs/heads/main". Empty if not known to be symbolic.
+}
+
+// lsRefs lists HEAD and branches of the git repository at remoteURL.
+func (r remoteGitHTTP) lsRefs(ctx context.Context, remoteURL string) ([]gitRemoteRef, error) {
+	// Discover refs and capabilities. Servers that support protocol version 2
+	// respond with their capabilities, and others advertise their refs right away.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, remoteURL+"/info/refs?service=git-upload-pack", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Git-Protocol", "version=2")
+	resp, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-git-upload-pack-advertisement" {
+		return nil, fmt.Errorf("%s: unexpected content type %q, the dumb HTTP protocol is not supported", req.URL.Redacted(), ct)
+	}
+	pr := newPktLineReader(resp.Body)
+	line, err := pr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "# service=git-upload-pack" {
+		// Servers may precede the response with a service announcement.
+		if line, err = pr.readLine(); err != nil || line != pktFlush {
+			return nil, fmt.Errorf("invalid service announcement: %q, %v", line, err)
+		}
+		if line, err = pr.readLine(); err != nil {
+			return nil, err
+		}
+	}
+	switch line {
+	case "version 1":
+		// Version 1 is the same as version 0, except for this line.
+		if line, err = pr.readLine(); err != nil {
+			return nil, err
+		}
+		fallthrough
+	default:
+		return parseGitV0Advertisement(pr, line)
+	case "version 2":
+	}
+
+	// Use protocol version 2.
+	var lsRefs bool
+	var objectFormat string
+	for {
+		line, err := pr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == pktFlush {
+			break
+		}
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "ls-refs":
+			lsRefs = true
+		case "object-format":
+			objectFormat = value
+		}
+	}
+	if !lsRefs {
+		return nil, errors.New("git server doesn't support ls-refs command")
+	}
+	var body bytes.Buffer
+	writePktLine(&body, "command=ls-refs\n")
+	if objectFormat != "" {
+		writePktLine(&body, "object-format="+objectFormat+"\n")
+	}
+	body.WriteString(pktDelim)
+	writePktLine(&body, "symrefs\n")
+	writePktLine(&body, "ref-prefix HEAD\n")
+	writePktLine(&body, "ref-prefix refs/heads/\n")
+	body.WriteString(pktFlush)
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, remoteURL+"/git-upload-pack", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	req.Header.Set("Accept", "application/x-git-upload-pack-result")
+	req.Header.Set("Git-Protocol", "version=2")
+	resp, err = r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return parseGitLsRefs(newPktLineReader(resp.Body))
+}
+
+// do sends an HTTP request, and classifies failures
+// like remoteError does for the git binary.
+func (r remoteGitHTTP) do(req *http.Request) (*http.Response, error) {
+	client := r.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req.Header.Set("User-Agent", "git/vcsstate")
+	resp, err := client.Do(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		return nil, req.Context().Err()
+	case err != nil && (errors.As(err, new(*net.OpError)) || errors.As(err, new(*net.DNSError))):
+		return nil, OfflineError{Err: err}
+	case err != nil:
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	resp.Body.Close()
+	err = fmt.Errorf("%s: %s", req.URL.Redacted(), resp.Status)
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, AuthenticationError{Err: err}
+	case http.StatusForbidden:
+		return nil, PermissionDeniedError{Err: err}
+	case http.StatusNotFound:
+		return nil, NotFoundError{Err: err}
+	default:
+		return nil, err
+	}
+}
+
+// parseGitV0Advertisement parses a protocol version 0 ref advertisement,
+// whose first line has already been read. E.g.:
+//
+//	7cafcd837844e784b526369c9bce262804aebc60 HEAD\x00multi_ack symref=HEAD:refs/heads/main agent=git/2.39.5
+//	7cafcd837844e784b526369c9bce262804aebc60 refs/heads/main
+//	0000
+func parseGitV0Advertisement(pr *pktLineReader, first string) ([]gitRemoteRef, error) {
+	first, capabilities, ok := strings.Cut(first, "\x00")
+	if !ok {
+		return nil, fmt.Errorf("invalid ref advertisement %q", first)
+	}
+	symrefs := make(map[string]string)
+	for _, c := range strings.Fields(capabilities) {
+		if strings.HasPrefix(c, "symref=") {
+			name, target, _ := strings.Cut(strings.TrimPrefix(c, "symref="), ":")
+			symrefs[name] = target
+		}
+	}
+	var refs []gitRemoteRef
+	for line := first; line != pktFlush; {
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid ref advertisement %q", line)
+		}
+		// An empty repository advertises capabilities^{}, and tags are followed by their peeled value.
+		if name != "capabilities^{}" && !strings.HasSuffix(name, "^{}") {
+			refs = append(refs, gitRemoteRef{Name: name, Hash: hash, Target: symrefs[name]})
+		}
+		var err error
+		if line, err = pr.readLine(); err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// parseGitLsRefs parses a protocol version 2 ls-refs response. E.g.:
+//
+//	7cafcd837844e784b526369c9bce262804aebc60 HEAD symref-target:refs/heads/main
+//	7cafcd837844e784b526369c9bce262804aebc60 refs/heads/main
+//	0000
+func parseGitLsRefs(pr *pktLineReader) ([]gitRemoteRef, error) {
+	var refs []gitRemoteRef
+	for {
+		line, err := pr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == pktFlush {
+			return refs, nil
+		}
+		fields := strings.Split(line, " ")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid ls-refs line %q", line)
+		}
+		ref := gitRemoteRef{Hash: fields[0], Name: fields[1]}
+		for _, attr := range fields[2:] {
+			if strings.HasPrefix(attr, "symref-target:") {
+				ref.Target = strings.TrimPrefix(attr, "symref-target:")
+			}
+		}
+		refs = append(refs, ref)
+	}
+}
+
+// gitRemoteHeadFromRefs determines the default branch from refs.
+// If the target of HEAD is not known, the branch is picked by pickBranch,
+// as guessBranch does.
+func gitRemoteHeadFromRefs(refs []gitRemoteRef) (RemoteHead, error) {
+	var head *gitRemoteRef
+	for i := range refs {
+		if refs[i].Name == "HEAD" {
+			head = &refs[i]
+		}
+	}
+	if head == nil {
+		return RemoteHead{}, errors.New("HEAD not found in advertised refs")
+	}
+	if strings.HasPrefix(head.Target, "refs/heads/") {
+		return RemoteHead{Branch: strings.TrimPrefix(head.Target, "refs/heads/"), Revision: head.Hash, Convention: SymbolicRef}, nil
+	}
+	var branches []string
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name, "refs/heads/") && ref.Hash == head.Hash {
+			branches = append(branches, strings.TrimPrefix(ref.Name, "refs/heads/"))
+		}
+	}
+	if len(branches) == 0 {
+		return RemoteHead{}, errBranchNotFound
+	}
+	return RemoteHead{Branch: pickBranch(branches), Revision: head.Hash, Convention: RevisionMatch}, nil
+}
+
+// Special packet lines. pktFlush is also what readLine returns for a flush packet.
+const (
+	pktFlush = "0000"
+	pktDelim = "0001"
+)
+
+// pktLineReader reads packet lines, as used by the git protocol.
+type pktLineReader struct {
+	br *bufio.Reader
+}
+
+func newPktLineReader(r io.Reader) *pktLineReader {
+	return &pktLineReader{br: bufio.NewReader(r)}
+}
+
+// readLine reads a packet line, and returns its data without the trailing newline.
+// A flush packet is returned as pktFlush. An error packet is returned as an error.
+func (pr *pktLineReader) readLine() (string, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(pr.br, size[:]); err != nil {
+		return "", fmt.Errorf("reading packet line: %v", err)
+	}
+	n, err := strconv.ParseUint(string(size[:]), 16, 16)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("invalid packet line length %q", size)
+	case n == 0:
+		return pktFlush, nil
+	case n < 4:
+		return "", fmt.Errorf("unexpected special packet %q", size)
+	}
+	data := make([]byte, n-4)
+	if _, err := io.ReadFull(pr.br, data); err != nil {
+		return "", fmt.Errorf("reading packet line: %v", err)
+	}
+	line := strings.TrimSuffix(string(data), "\n")
+	if strings.HasPrefix(line, "ERR ") {
+		return "", fmt.Errorf("git server error: %s", strings.TrimPrefix(line, "ERR "))
+	}
+	return line, nil
+}
+
+// writePktLine writes data as a packet line.
+func writePktLine(w io.Writer, data string) {
+	fmt.Fprintf(w, "%04x%s", len(data)+4, data)
+}
@@ -0,0 +1,162 @@
+package vcsstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRemoteGitHTTPStandIn(t *testing.T) {
+	const (
+		main  = "7cafcd837844e784b526369c9bce262804aebc60"
+		other = "2c0ec5b6ba6a2ba3b1e2d9b09f4c8fb3c2b0f1a2"
+	)
+	pkt := func(lines ...string) string {
+		var b strings.Builder
+		for _, line := range lines {
+			if line == pktFlush {
+				b.WriteString(pktFlush)
+				continue
+			}
+			writePktLine(&b, line+"\n")
+		}
+		return b.String()
+	}
+	for _, tc := range []struct {
+		name    string
+		status  int
+		body    string
+		want    RemoteHead
+		wantErr interface{}
+	}{{
+		name: "no symref",
+		body: pkt("# service=git-upload-pack", pktFlush,
+			main+" HEAD\x00multi_ack side-band-64k",
+			other+" refs/heads/feature",
+			main+" refs/heads/main",
+			main+" refs/heads/master",
+			pktFlush),
+		want: RemoteHead{Branch: "master", Revision: main, Convention: RevisionMatch},
+	}, {
+		name: "symref",
+		body: pkt(main+" HEAD\x00symref=HEAD:refs/heads/main",
+			main+" refs/heads/main",
+			main+" refs/heads/master",
+			pktFlush),
+		want: RemoteHead{Branch: "main", Revision: main, Convention: SymbolicRef},
+	}, {
+		name:    "empty",
+		body:    pkt("0000000000000000000000000000000000000000 capabilities^{}\x00multi_ack", pktFlush),
+		wantErr: new(error),
+	}, {
+		name:    "error packet",
+		body:    pkt("ERR access denied or repository not exported"),
+		wantErr: new(error),
+	}, {
+		name:    "unauthorized",
+		status:  http.StatusUnauthorized,
+		wantErr: new(AuthenticationError),
+	}, {
+		name:    "forbidden",
+		status:  http.StatusForbidden,
+		wantErr: new(PermissionDeniedError),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/repo/info/refs" || req.URL.Query().Get("service") != "git-upload-pack" {
+					http.NotFound(w, req)
+					return
+				}
+				if tc.status != 0 {
+					http.Error(w, http.StatusText(tc.status), tc.status)
+					return
+				}
+				w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+				fmt.Fprint(w, tc.body)
+			}))
+			defer ts.Close()
+			rv := backgroundRemoteVCS{remoteGitHTTP{client: ts.Client()}}
+
+			got, err := rv.RemoteHead(ts.URL + "/repo/")
+			if tc.wantErr != nil {
+				if err == nil || !errors.As(err, tc.wantErr) {
+					t.Errorf("got error %v, want %T", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRemoteGitHTTPUnsupportedURL(t *testing.T) {
+	_, err := remoteGitHTTP{}.RemoteHeadContext(context.Background(), "git@github.com:shurcooL/vcsstate.git")
+	if err == nil {
+		t.Error("got nil error for ssh remote URL, want non-nil")
+	}
+}
+
+func TestGitRemoteHeadFromRefs(t *testing.T) {
+	const (
+		head  = "fbbaff1827317122a8a0e1b24de25df8417ce87b"
+		other = "0000000000000000000000000000000000000001"
+	)
+	tests := []struct {
+		refs    []gitRemoteRef
+		want    RemoteHead
+		wantErr error
+	}{
+		{
+			refs: []gitRemoteRef{
+				{Name: "HEAD", Hash: head, Target: "refs/heads/trunk"},
+				{Name: "refs/heads/master", Hash: head},
+				{Name: "refs/heads/trunk", Hash: head},
+			},
+			want: RemoteHead{Branch: "trunk", Revision: head, Convention: SymbolicRef},
+		},
+		{
+			refs: []gitRemoteRef{
+				{Name: "HEAD", Hash: head},
+				{Name: "refs/heads/release", Hash: head},
+				{Name: "refs/heads/master", Hash: head},
+				{Name: "refs/heads/develop", Hash: head},
+			},
+			want: RemoteHead{Branch: "master", Revision: head, Convention: RevisionMatch},
+		},
+		{
+			refs: []gitRemoteRef{
+				{Name: "HEAD", Hash: head},
+				{Name: "refs/heads/release", Hash: head},
+				{Name: "refs/heads/zzz", Hash: other},
+				{Name: "refs/heads/develop", Hash: head},
+			},
+			want: RemoteHead{Branch: "release", Revision: head, Convention: RevisionMatch},
+		},
+		{
+			refs: []gitRemoteRef{
+				{Name: "HEAD", Hash: head},
+				{Name: "refs/heads/other", Hash: other},
+			},
+			wantErr: errBranchNotFound,
+		},
+	}
+	for _, test := range tests {
+		got, err := gitRemoteHeadFromRefs(test.refs)
+		if err != test.wantErr {
+			t.Errorf("got error %v, want %v", err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("got %+v, want %+v", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package vcsstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitNative is a git implementation that reads the repository directly,
+// without using the git binary. Methods that need to inspect the working tree
+// or use the network are not supported, and they return ErrUnsupported.
+type gitNative struct {
+	remote string // Remote name, as given by Options.Remote.
+}
+
+func (gitNative) StatusContext(ctx context.Context, dir string) (string, error) {
+	return "", ErrUnsupported
+}
+
+func (gitNative) WorkingTreeStatusContext(ctx context.Context, dir string) ([]FileStatus, error) {
+	return nil, ErrUnsupported
+}
+
+func (g gitNative) BranchContext(ctx context.Context, dir string) (string, error) {
+	info, err := g.BranchInfoContext(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	if info.State == Detached {
+		// Same as git rev-parse --abbrev-ref HEAD.
+		return "HEAD", nil
+	}
+	return info.Name, nil
+}
+
+func (gitNative) BranchInfoContext(ctx context.Context, dir string) (BranchInfo, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return BranchInfo{}, err
+	}
+	defer r.close()
+
+	return r.branchInfo()
+}
+
+// branchInfo returns information about what is checked out
+// in the working tree of r.gitDir.
+func (r *gitRepo) branchInfo() (BranchInfo, error) {
+	target, symbolic, err := r.readRef("HEAD")
+	if err != nil {
+		return BranchInfo{}, err
+	}
+	if !symbolic {
+		return BranchInfo{State: Detached, Revision: target}, nil
+	}
+	info := BranchInfo{Name: strings.TrimPrefix(target, "refs/heads/")}
+	info.Revision, err = r.resolveRef(target)
+	switch {
+	case err == errRefNotFound:
+		info.State = Unborn
+	case err != nil:
+		return BranchInfo{}, err
+	}
+	return info, nil
+}
+
+func (gitNative) LocalRevisionContext(ctx context.Context, dir string, defaultBranch string) (string, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	defer r.close()
+
+	revision, err := r.resolveRevision(defaultBranch)
+	if err == errRefNotFound {
+		return "", fmt.Errorf("%w %q", ErrUnknownRevision, defaultBranch)
+	}
+	return revision, err
+}
+
+func (gitNative) StashContext(ctx context.Context, dir string) (string, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	defer r.close()
+
+	_, err = r.resolveRef("refs/stash")
+	switch {
+	case err == errRefNotFound:
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+	// Format the stash reflog like git stash list does, newest first.
+	// E.g., "abc... def... A U Thor <author@example.com> 1700000000 +0000\tWIP on main: 5f1e2d3 subject\n".
+	b, err := os.ReadFile(filepath.Join(r.commonDir, "logs", "refs", "stash"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "stash@{0}\n", nil
+	} else if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	var list strings.Builder
+	for i := range lines {
+		_, message, _ := strings.Cut(lines[len(lines)-1-i], "\t")
+		fmt.Fprintf(&list, "stash@{%d}: %s\n", i, message)
+	}
+	return list.String(), nil
+}
+
+func (gitNative) ContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return false, err
+	}
+	defer r.close()
+
+	return r.contains(ctx, revision, "refs/heads/"+defaultBranch)
+}
+
+func (g gitNative) RemoteContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return false, err
+	}
+	defer r.close()
+
+	remote, err := r.remoteName(g.remote)
+	if err != nil {
+		return false, err
+	}
+	if len(r.config.getAll("remote."+remote+".url")) == 0 {
+		return false, ErrNoRemote
+	}
+	return r.contains(ctx, revision, "refs/remotes/"+remote+"/"+defaultBranch)
+}
+
+// contains reports whether the commit specified by revision
+// is reachable from ref. It's false if either doesn't exist.
+func (r *gitRepo) contains(ctx context.Context, revision string, ref string) (bool, error) {
+	tip, err := r.resolveRef(ref)
+	if err == errRefNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	hash, err := r.resolveRevision(revision)
+	if err == errRefNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	hash, _, err = r.peelToCommit(hash)
+	if err == errObjectNotFound {
+		return false, nil // No such commit means this commit is not contained.
+	} else if err != nil {
+		return false, err
+	}
+	tip, _, err = r.peelToCommit(tip)
+	if err != nil {
+		return false, err
+	}
+	return r.isAncestor(ctx, hash, tip)
+}
+
+func (gitNative) AheadBehindContext(ctx context.Context, dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error) {
+	return 0, 0, "", ErrUnsupported
+}
+
+func (g gitNative) RemoteURLContext(ctx context.Context, dir string) (string, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	defer r.close()
+
+	remote, err := r.remoteName(g.remote)
+	if err != nil {
+		return "", err
+	}
+	urls := r.config.getAll("remote." + remote + ".url")
+	if len(urls) == 0 {
+		return "", ErrNoRemote
+	}
+	return r.rewriteURL(urls[0]), nil
+}
+
+// rewriteURL applies the longest matching url.<base>.insteadOf
+// configuration to url, like git remote get-url does.
+func (r *gitRepo) rewriteURL(url string) string {
+	var base, prefix string
+	for _, e := range r.config {
+		if !strings.HasPrefix(e.Key, "url.") || !strings.HasSuffix(e.Key, ".insteadof") {
+			continue
+		}
+		if strings.HasPrefix(url, e.Value) && len(e.Value) > len(prefix) {
+			base, prefix = strings.TrimSuffix(strings.TrimPrefix(e.Key, "url."), ".insteadof"), e.Value
+		}
+	}
+	if prefix == "" {
+		return url
+	}
+	return base + url[len(prefix):]
+}
+
+func (gitNative) RemoteHeadContext(ctx context.Context, dir string) (RemoteHead, error) {
+	return RemoteHead{}, ErrUnsupported
+}
+
+func (g gitNative) CachedRemoteDefaultBranchContext(ctx context.Context, dir string) (string, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	defer r.close()
+
+	remote, err := r.remoteName(g.remote)
+	if err != nil {
+		return "", err
+	}
+	// The remote HEAD is cached in refs/remotes/{remote}/HEAD by git clone and git remote set-head.
+	target, symbolic, err := r.readRef("refs/remotes/" + remote + "/HEAD")
+	switch {
+	case err == errRefNotFound || err == nil && !symbolic:
+		return "", fmt.Errorf("no cached remote HEAD for %s, fall back to NoRemoteDefaultBranch", remote)
+	case err != nil:
+		return "", err
+	}
+	prefix := "refs/remotes/" + remote + "/"
+	if !strings.HasPrefix(target, prefix) {
+		return "", fmt.Errorf("cached remote HEAD %q is outside of %q", target, prefix)
+	}
+	return target[len(prefix):], nil
+}
+
+func (g gitNative) GuessDefaultBranchContext(ctx context.Context, dir string) (branch string, source DefaultBranchSource, err error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return "", 0, err
+	}
+	defer r.close()
+
+	configured, _ := r.config.get("init.defaultbranch")
+	exist := make(map[string]bool)
+	for _, b := range append([]string{configured}, conventionalDefaultBranches...) {
+		if b == "" {
+			continue
+		}
+		_, _, err := r.readRef("refs/heads/" + b)
+		switch {
+		case err == nil:
+			exist[b] = true
+		case err != errRefNotFound:
+			return "", 0, err
+		}
+	}
+	branch, source = guessDefaultBranch(configured, exist, g.NoRemoteDefaultBranch())
+	return branch, source, nil
+}
+
+func (gitNative) SnapshotContext(ctx context.Context, dir string) (Snapshot, error) {
+	// A snapshot includes the working tree status, which isn't supported.
+	return Snapshot{}, ErrUnsupported
+}
+
+func (gitNative) WorktreesContext(ctx context.Context, dir string) ([]Worktree, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	return r.worktrees()
+}
+
+func (gitNative) IsMainWorktreeContext(ctx context.Context, dir string) (bool, error) {
+	r, err := openGitRepo(dir)
+	if err != nil {
+		return false, err
+	}
+	defer r.close()
+
+	return r.gitDir == r.commonDir, nil
+}
+
+func (gitNative) NoRemoteDefaultBranch() string {
+	return "master"
+}
+
+// remoteName returns the name of the remote to use, like gitRemote does.
+func (r *gitRepo) remoteName(remote string) (string, error) {
+	switch remote {
+	case "":
+		return "origin", nil
+	default:
+		return remote, nil
+	case UpstreamRemote:
+		// Use the remote of the checked out branch's configured upstream below.
+	}
+	target, symbolic, err := r.readRef("HEAD")
+	if err != nil {
+		return "", err
+	}
+	if !symbolic {
+		return "", ErrNoRemote // HEAD is detached, so there's no upstream.
+	}
+	branch := strings.TrimPrefix(target, "refs/heads/")
+	remote, ok := r.config.get("branch." + branch + ".remote")
+	if !ok || remote == "." {
+		return "", ErrNoRemote // No upstream is configured, or it's a branch in the local repository.
+	}
+	return remote, nil
+}
+
+// worktrees returns the working trees of r, like git worktree list does.
+// Linked working trees are described by the worktrees directory
+// of the common git directory, one subdirectory each.
+func (r *gitRepo) worktrees() ([]Worktree, error) {
+	main := Worktree{Path: r.commonDir, Main: true}
+	if bare, _ := r.config.get("core.bare"); bare == "true" {
+		main.Bare = true
+	} else {
+		if filepath.Base(r.commonDir) == ".git" {
+			main.Path = filepath.Dir(r.commonDir)
+		}
+		var err error
+		main.Branch, err = r.withGitDir(r.commonDir).branchInfo()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Same as git, which reports the real path of the main working tree.
+	if path, err := filepath.EvalSymlinks(main.Path); err == nil {
+		main.Path = path
+	}
+	worktrees := []Worktree{main}
+
+	entries, err := os.ReadDir(filepath.Join(r.commonDir, "worktrees"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		gitDir := filepath.Join(r.commonDir, "worktrees", e.Name())
+		// The gitdir file has the path of the .git file in the linked working tree.
+		b, err := os.ReadFile(filepath.Join(gitDir, "gitdir"))
+		if err != nil {
+			// Same as git, which skips linked working trees without a gitdir file.
+			continue
+		}
+		dotGit := strings.TrimSpace(string(b))
+		if !filepath.IsAbs(dotGit) {
+			dotGit = filepath.Join(gitDir, dotGit)
+		}
+		w := Worktree{Path: filepath.Dir(dotGit)}
+		if b, err := os.ReadFile(filepath.Join(gitDir, "locked")); err == nil {
+			w.Locked, w.LockReason = true, strings.TrimSpace(string(b))
+		} else if _, err := os.Stat(dotGit); errors.Is(err, os.ErrNotExist) {
+			w.Prunable, w.PruneReason = true, "gitdir file points to non-existent location"
+		}
+		w.Branch, err = r.withGitDir(gitDir).branchInfo()
+		if err != nil {
+			return nil, err
+		}
+		worktrees = append(worktrees, w)
+	}
+	sortLinkedWorktrees(worktrees)
+	return worktrees, nil
+}
+
+// withGitDir returns a copy of r for the working tree whose git directory is gitDir.
+// It's meant for reading refs, such as HEAD, so only r needs to be closed.
+func (r *gitRepo) withGitDir(gitDir string) *gitRepo {
+	w := *r
+	w.gitDir = gitDir
+	return &w
+}
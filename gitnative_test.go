@@ -0,0 +1,324 @@
+package vcsstate_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shurcooL/vcsstate"
+	"github.com/shurcooL/vcsstate/vcsstatetest"
+)
+
+// newGit creates a git VCS configured by opt, and fails the test on error.
+func newGit(t testing.TB, opt *vcsstate.Options) vcsstate.VCS {
+	t.Helper()
+	v, err := vcsstate.New(vcsstate.Git, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// compareGitNative checks that Options.NativeGit gives the same results
+// as the git binary for the repository at dir.
+func compareGitNative(t *testing.T, dir string, revisions []string, branches []string) {
+	t.Helper()
+	want, got := newGit(t, nil), newGit(t, &vcsstate.Options{NativeGit: true})
+	type query struct {
+		name string
+		f    func(v vcsstate.VCS) (interface{}, error)
+	}
+	queries := []query{
+		{"BranchInfo", func(v vcsstate.VCS) (interface{}, error) { return v.BranchInfo(dir) }},
+		{"Stash", func(v vcsstate.VCS) (interface{}, error) { return v.Stash(dir) }},
+		{"Worktrees", func(v vcsstate.VCS) (interface{}, error) { return v.Worktrees(dir) }},
+		{"IsMainWorktree", func(v vcsstate.VCS) (interface{}, error) { return v.IsMainWorktree(dir) }},
+		{"RemoteURL", func(v vcsstate.VCS) (interface{}, error) { return v.RemoteURL(dir) }},
+		{"CachedRemoteDefaultBranch", func(v vcsstate.VCS) (interface{}, error) { return v.CachedRemoteDefaultBranch(dir) }},
+		{"GuessDefaultBranch", func(v vcsstate.VCS) (interface{}, error) {
+			branch, source, err := v.GuessDefaultBranch(dir)
+			return [2]interface{}{branch, source}, err
+		}},
+	}
+	if info, err := want.BranchInfo(dir); err == nil && info.State != vcsstate.Unborn {
+		queries = append(queries, query{"Branch", func(v vcsstate.VCS) (interface{}, error) { return v.Branch(dir) }})
+	}
+	for _, b := range branches {
+		b := b
+		queries = append(queries, query{"LocalRevision " + b, func(v vcsstate.VCS) (interface{}, error) { return v.LocalRevision(dir, b) }})
+		for _, rev := range revisions {
+			rev := rev
+			queries = append(queries,
+				query{"Contains " + rev + " " + b, func(v vcsstate.VCS) (interface{}, error) { return v.Contains(dir, rev, b) }},
+				query{"RemoteContains " + rev + " " + b, func(v vcsstate.VCS) (interface{}, error) { return v.RemoteContains(dir, rev, b) }},
+			)
+		}
+	}
+	for _, q := range queries {
+		w, wErr := q.f(want)
+		g, gErr := q.f(got)
+		if (wErr != nil) != (gErr != nil) {
+			t.Errorf("%s: got error %v, want %v", q.name, gErr, wErr)
+		} else if wErr == nil && !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: got %v, want %v", q.name, g, w)
+		}
+	}
+}
+
+func TestGitNative(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	remote := repos.Init("remote")
+	repos.Run(remote, "commit", "--quiet", "--allow-empty", "-m", "first")
+	repos.Run(remote, "commit", "--quiet", "--allow-empty", "-m", "second")
+	repos.Run(remote, "tag", "-a", "-m", "version 1", "v1")
+	repos.Run(remote, "checkout", "--quiet", "-b", "feature")
+	repos.Run(remote, "commit", "--quiet", "--allow-empty", "-m", "feature")
+	repos.Run(remote, "checkout", "--quiet", "main")
+	repos.Run("", "clone", "--quiet", "--no-local", remote, "local")
+	local := filepath.Join(repos.Root, "local")
+	repos.Run(local, "commit", "--quiet", "--allow-empty", "-m", "local")
+	repos.Run(local, "branch", "nested/branch", "HEAD~2")
+	repos.WriteFile(local, "file", "stashed\n")
+	repos.Run(local, "add", "file")
+	repos.Run(local, "stash", "--quiet")
+
+	revisions := []string{
+		repos.Run(local, "rev-parse", "main"),
+		repos.Run(local, "rev-parse", "main~1"),
+		repos.Run(local, "rev-parse", "main~2"),
+		repos.Run(local, "rev-parse", "origin/feature"),
+		"v1",
+		"0123456789012345678901234567890123456789", // No such commit.
+	}
+	branches := []string{"main", "feature", "nested/branch", "v1", "origin/main", "missing"}
+
+	t.Run("loose", func(t *testing.T) {
+		compareGitNative(t, local, revisions, branches)
+	})
+	repos.Run(local, "gc", "--quiet")
+	if _, err := os.Stat(filepath.Join(local, ".git", "packed-refs")); err != nil {
+		t.Fatal("git gc didn't pack refs:", err)
+	}
+	t.Run("packed", func(t *testing.T) {
+		compareGitNative(t, local, revisions, branches)
+	})
+
+	worktree := filepath.Join(repos.Root, "worktree")
+	repos.Run(local, "worktree", "add", "--quiet", "--detach", worktree, "main~1")
+	t.Run("worktree", func(t *testing.T) {
+		compareGitNative(t, worktree, revisions, branches)
+	})
+	locked, deleted := filepath.Join(repos.Root, "locked"), filepath.Join(repos.Root, "deleted")
+	repos.Run(local, "worktree", "add", "--quiet", "-b", "other", locked, "main~2")
+	repos.Run(local, "worktree", "lock", "--reason", "on a removable drive", locked)
+	repos.Run(local, "worktree", "add", "--quiet", "--detach", deleted)
+	if err := os.RemoveAll(deleted); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("worktrees", func(t *testing.T) {
+		compareGitNative(t, local, nil, nil)
+		compareGitNative(t, locked, nil, nil)
+		worktrees, err := newGit(t, &vcsstate.Options{NativeGit: true}).Worktrees(local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(worktrees) != 4 || !worktrees[0].Main || !worktrees[1].Prunable || !worktrees[2].Locked {
+			t.Errorf("got %+v, want main, deleted, locked and worktree", worktrees)
+		}
+	})
+
+	repos.Run(local, "config", "url.https://example.com/.insteadOf", filepath.Dir(remote)+"/")
+	repos.Run(local, "config", "branch.main.remote", ".")
+	t.Run("config", func(t *testing.T) {
+		compareGitNative(t, local, nil, nil)
+		v := newGit(t, &vcsstate.Options{NativeGit: true, Remote: vcsstate.UpstreamRemote})
+		if _, err := v.RemoteURL(local); err != vcsstate.ErrNoRemote {
+			t.Errorf("got error %v, want ErrNoRemote", err)
+		}
+	})
+}
+
+func TestGitNativeUnborn(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	dir := repos.Init("repo")
+	repos.Run(dir, "symbolic-ref", "HEAD", "refs/heads/trunk")
+	compareGitNative(t, dir, nil, nil)
+}
+
+func TestGitNativeNoRemote(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	dir := repos.Init("repo")
+	repos.Commit(dir, "first")
+	for _, opt := range []*vcsstate.Options{nil, {NativeGit: true}} {
+		if _, err := newGit(t, opt).RemoteContains(dir, "main", "main"); err != vcsstate.ErrNoRemote {
+			t.Errorf("%+v: RemoteContains: got error %v, want ErrNoRemote", opt, err)
+		}
+	}
+}
+
+func TestGitNativeSHA256(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	dir := filepath.Join(repos.Root, "repo")
+	if _, err := exec.Command("git", "init", "--quiet", "--object-format=sha256", dir).CombinedOutput(); err != nil {
+		t.Skip("git doesn't support SHA-256:", err)
+	}
+	repos.Run(dir, "commit", "--quiet", "--allow-empty", "-m", "first")
+	repos.Run(dir, "commit", "--quiet", "--allow-empty", "-m", "second")
+	revisions := []string{repos.Run(dir, "rev-parse", "HEAD~1")}
+	branches := []string{repos.Run(dir, "branch", "--show-current")}
+
+	compareGitNative(t, dir, revisions, branches)
+	repos.Run(dir, "gc", "--quiet")
+	compareGitNative(t, dir, revisions, branches)
+}
+
+func TestGitRepoReadObjectDelta(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	dir := repos.Init("repo")
+	var content []string
+	for i := 0; i < 1000; i++ {
+		content = append(content, strings.Repeat("line ", i%10))
+	}
+	for i := 0; i < 5; i++ {
+		content[i*100] = "changed"
+		repos.WriteFile(dir, "file", strings.Join(content, "\n"))
+		repos.Run(dir, "add", "file")
+		repos.Run(dir, "commit", "--quiet", "-m", "version")
+	}
+	repos.Run(dir, "repack", "-a", "-d", "-f", "--quiet")
+	if !strings.HasPrefix(repos.Run(dir, "count-objects", "-v"), "count: 0\n") {
+		t.Fatal("git repack left loose objects")
+	}
+	idxs, err := filepath.Glob(filepath.Join(dir, ".git", "objects", "pack", "*.idx"))
+	if err != nil || len(idxs) != 1 {
+		t.Fatalf("got pack indexes %q, %v, want one", idxs, err)
+	}
+	if !strings.Contains(repos.Run(dir, "verify-pack", "-v", idxs[0]), "chain length = ") {
+		t.Fatal("git repack didn't create deltas")
+	}
+
+	r, err := vcsstate.OpenGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i := 0; i < 5; i++ {
+		hash := repos.Run(dir, "rev-parse", fmt.Sprintf("HEAD~%d:file", i))
+		typ, data, err := r.ReadObject(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := repos.Run(dir, "cat-file", "blob", hash); typ != "blob" || string(data) != want {
+			t.Errorf("object %s: got %v of length %v, want blob of length %v", hash, typ, len(data), len(want))
+		}
+	}
+	if _, _, err := r.ReadObject(strings.Repeat("0", 40)); err != vcsstate.ErrGitObjectNotFound {
+		t.Errorf("got error %v, want ErrGitObjectNotFound", err)
+	}
+}
+
+// gitFastImportHistory creates a repository named name, with a main branch of the commits
+// dated by times, oldest first, and a side branch of one commit dated sideTime,
+// forked from the parent of main, and returns its directory.
+// It uses git fast-import, so deep histories are cheap.
+func gitFastImportHistory(t testing.TB, repos *vcsstatetest.TempRepos, name string, times []int64, sideTime int64) string {
+	t.Helper()
+	dir := repos.Init(name)
+	var stream strings.Builder
+	for i, time := range times {
+		fmt.Fprintf(&stream, "commit refs/heads/main\nmark :%d\ncommitter test <test@example.com> %d +0000\ndata 0\n\n", i+1, time)
+	}
+	fmt.Fprintf(&stream, "commit refs/heads/side\ncommitter test <test@example.com> %d +0000\ndata 0\nfrom :%d\n\n", sideTime, len(times)-1)
+	cmd := exec.Command("git", "fast-import", "--quiet")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stream.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git fast-import: %v: %s", err, out)
+	}
+	return dir
+}
+
+func TestGitRepoIsAncestor(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	// The second commit is dated days before the root one, as if the clock of its committer was off.
+	const start = 1577836800
+	dir := gitFastImportHistory(t, repos, "repo", []int64{start, start - 3*24*3600, start + 3600, start + 7200}, start+10*3600)
+
+	r, err := vcsstate.OpenGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	tip, side := repos.Run(dir, "rev-parse", "main"), repos.Run(dir, "rev-parse", "side")
+	for _, tt := range []struct {
+		ancestor string
+		want     bool
+	}{
+		{"main", true},
+		{"main~1", true},
+		{"main~3", true}, // Reached through the skewed commit.
+		{"side", false},
+	} {
+		ancestor := repos.Run(dir, "rev-parse", tt.ancestor)
+		if got, err := r.IsAncestor(context.Background(), ancestor, tip); err != nil || got != tt.want {
+			t.Errorf("IsAncestor(%s, main): got %v, %v, want %v", tt.ancestor, got, err, tt.want)
+		}
+	}
+	if got, err := r.IsAncestor(context.Background(), tip, side); err != nil || got {
+		t.Errorf("IsAncestor(main, side): got %v, %v, want false", got, err)
+	}
+}
+
+// BenchmarkGitRepoIsAncestor measures IsAncestor on a deep history,
+// when ancestor is a recent commit that isn't reachable, and when it's the root commit.
+func BenchmarkGitRepoIsAncestor(b *testing.B) {
+	repos := vcsstatetest.NewTempGitRepos(b)
+	const start, n = 1577836800, 10000
+	times := make([]int64, n)
+	for i := range times {
+		times[i] = start + int64(i)*3600
+	}
+	dir := gitFastImportHistory(b, repos, "repo", times, start+n*3600)
+
+	r, err := vcsstate.OpenGitRepo(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+	tip := repos.Run(dir, "rev-parse", "main")
+	for _, bb := range []struct {
+		name     string
+		ancestor string
+		want     bool
+	}{
+		{"miss", repos.Run(dir, "rev-parse", "side"), false},
+		{"root", repos.Run(dir, "rev-list", "--max-parents=0", "main"), true},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if got, err := r.IsAncestor(context.Background(), bb.ancestor, tip); err != nil || got != bb.want {
+					b.Fatalf("got %v, %v, want %v", got, err, bb.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGitNativeContextCanceled(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	dir := repos.Init("repo")
+	first := repos.Commit(dir, "first")
+	repos.Commit(dir, "second")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := newGit(t, &vcsstate.Options{NativeGit: true}).ContainsContext(ctx, dir, first, "main")
+	if got, want := err, context.Canceled; got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
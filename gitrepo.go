@@ -0,0 +1,1053 @@
+package vcsstate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gitRepo is a git repository that is read directly from disk,
+// without using the git binary. It supports loose and packed refs,
+// loose and packed objects (including deltas), alternates, worktrees,
+// shallow clones, and both the SHA-1 and SHA-256 object formats.
+// It doesn't support the reftable ref storage format.
+type gitRepo struct {
+	gitDir    string // Directory with HEAD of the working tree, e.g., ".git" or ".git/worktrees/<name>".
+	commonDir string // Directory with refs, objects and config shared by all working trees.
+	config    gitConfig
+	hashSize  int // Size of object hash in bytes.
+
+	objectDirs []string   // Object directories, starting with the repository's own, followed by alternates.
+	packs      []*gitPack // Loaded on first use.
+	packsErr   error
+	loaded     bool
+
+	packedRefs map[string]string // Loaded on first use.
+	shallow    map[string]bool   // Loaded on first use.
+	deltaBases map[gitPackOffset]gitObject
+}
+
+// openGitRepo opens the git repository that contains dir.
+// The caller must close it when done.
+func openGitRepo(dir string) (*gitRepo, error) {
+	gitDir, err := findGitDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	commonDir := gitDir
+	if b, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		// It's a linked working tree, created by git worktree add.
+		commonDir = strings.TrimSuffix(string(b), "\n")
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	config, err := loadGitConfig(filepath.Join(commonDir, "config"))
+	if err != nil {
+		return nil, err
+	}
+	r := &gitRepo{
+		gitDir:    gitDir,
+		commonDir: commonDir,
+		config:    config,
+		hashSize:  sha1.Size,
+	}
+	switch format, _ := config.get("extensions.objectformat"); format {
+	case "", "sha1":
+	case "sha256":
+		r.hashSize = sha256.Size
+	default:
+		return nil, fmt.Errorf("unsupported object format %q", format)
+	}
+	if storage, _ := config.get("extensions.refstorage"); storage != "" && storage != "files" {
+		return nil, fmt.Errorf("unsupported ref storage format %q", storage)
+	}
+	r.objectDirs = gitObjectDirs(filepath.Join(commonDir, "objects"), 0)
+	return r, nil
+}
+
+// findGitDir finds the git directory of the repository that contains dir,
+// by looking for .git in dir and its parents. The .git can be a directory,
+// or a file that points to the git directory elsewhere, as used by
+// linked working trees and submodules.
+func findGitDir(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for d := dir; ; {
+		path := filepath.Join(d, ".git")
+		fi, err := os.Stat(path)
+		switch {
+		case err == nil && fi.IsDir():
+			return path, nil
+		case err == nil:
+			// E.g., "gitdir: /path/to/repo/.git/worktrees/name\n".
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			gitDir := strings.TrimSpace(string(b))
+			if !strings.HasPrefix(gitDir, "gitdir: ") {
+				return "", fmt.Errorf("invalid .git file %q", path)
+			}
+			gitDir = strings.TrimPrefix(gitDir, "gitdir: ")
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(d, gitDir)
+			}
+			return gitDir, nil
+		case !os.IsNotExist(err):
+			return "", err
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return "", fmt.Errorf("not a git repository (or any of the parent directories): %s", dir)
+		}
+		d = parent
+	}
+}
+
+// gitObjectDirs returns objectDir followed by its alternates, recursively.
+func gitObjectDirs(objectDir string, depth int) []string {
+	dirs := []string{objectDir}
+	if depth >= 5 {
+		// Same limit as git.
+		return dirs
+	}
+	b, err := os.ReadFile(filepath.Join(objectDir, "info", "alternates"))
+	if err != nil {
+		return dirs
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(objectDir, line)
+		}
+		dirs = append(dirs, gitObjectDirs(line, depth+1)...)
+	}
+	return dirs
+}
+
+// close closes the pack files that were opened.
+func (r *gitRepo) close() error {
+	var err error
+	for _, p := range r.packs {
+		if p.f == nil {
+			continue
+		}
+		if err1 := p.f.Close(); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
+
+// errRefNotFound is returned when a ref doesn't exist.
+var errRefNotFound = errors.New("ref not found")
+
+// readRef reads the ref named name without following symbolic refs.
+// If it's a symbolic ref, target is the name of the ref it points to.
+// Otherwise, target is the hash of the object it points to.
+// It returns errRefNotFound if the ref doesn't exist.
+func (r *gitRepo) readRef(name string) (target string, symbolic bool, err error) {
+	if !validGitRefName(name) {
+		return "", false, fmt.Errorf("invalid ref name %q", name)
+	}
+	dir := r.commonDir
+	if !strings.HasPrefix(name, "refs/") ||
+		strings.HasPrefix(name, "refs/bisect/") ||
+		strings.HasPrefix(name, "refs/worktree/") ||
+		strings.HasPrefix(name, "refs/rewritten/") {
+		// HEAD, other pseudorefs, and a few refs are specific to each working tree.
+		dir = r.gitDir
+	}
+	b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	switch {
+	case err == nil:
+		line := strings.TrimRight(string(b), "\r\n")
+		if strings.HasPrefix(line, "ref: ") {
+			return strings.TrimPrefix(line, "ref: "), true, nil
+		}
+		if len(line) < 2*r.hashSize || !isHex(line[:2*r.hashSize]) {
+			return "", false, fmt.Errorf("invalid ref %q: %q", name, line)
+		}
+		// Only the first line matters, e.g., FETCH_HEAD may have more.
+		return line[:2*r.hashSize], false, nil
+	case errors.Is(err, os.ErrNotExist) || isDirError(err):
+		// Fall back to packed-refs below.
+	default:
+		return "", false, err
+	}
+	if !strings.HasPrefix(name, "refs/") {
+		return "", false, errRefNotFound
+	}
+	if r.packedRefs == nil {
+		r.packedRefs, err = readGitPackedRefs(filepath.Join(r.commonDir, "packed-refs"))
+		if err != nil {
+			return "", false, err
+		}
+	}
+	hash, ok := r.packedRefs[name]
+	if !ok {
+		return "", false, errRefNotFound
+	}
+	return hash, false, nil
+}
+
+// isDirError reports whether err is the error from reading a directory as a file.
+// E.g., reading ref refs/heads/a when refs/heads/a/b exists.
+func isDirError(err error) bool {
+	var pe *os.PathError
+	if !errors.As(err, &pe) {
+		return false
+	}
+	fi, statErr := os.Stat(pe.Path)
+	return statErr == nil && fi.IsDir()
+}
+
+// resolveRef reads the ref named name, following symbolic refs,
+// and returns the hash of the object it points to.
+// It returns errRefNotFound if the ref or a ref it points to doesn't exist.
+func (r *gitRepo) resolveRef(name string) (string, error) {
+	for i := 0; i < 5; i++ { // Same limit as git.
+		target, symbolic, err := r.readRef(name)
+		if err != nil {
+			return "", err
+		}
+		if !symbolic {
+			return target, nil
+		}
+		name = target
+	}
+	return "", fmt.Errorf("symbolic ref %q nested too deeply", name)
+}
+
+// resolveRevision resolves revision, which is either a full object hash or
+// a ref name, to an object hash. Ref names are looked up in the same order
+// as git rev-parse does, e.g., "main" is looked up as "refs/tags/main"
+// before "refs/heads/main". It returns errRefNotFound if it can't be resolved.
+func (r *gitRepo) resolveRevision(revision string) (string, error) {
+	if len(revision) == 2*r.hashSize && isHex(revision) {
+		return strings.ToLower(revision), nil
+	}
+	if revision == "@" {
+		revision = "HEAD"
+	}
+	var names []string
+	if strings.HasPrefix(revision, "refs/") || isPseudoRefName(revision) {
+		names = append(names, revision)
+	}
+	names = append(names,
+		"refs/"+revision,
+		"refs/tags/"+revision,
+		"refs/heads/"+revision,
+		"refs/remotes/"+revision,
+		"refs/remotes/"+revision+"/HEAD",
+	)
+	for _, name := range names {
+		if !validGitRefName(name) {
+			continue
+		}
+		hash, err := r.resolveRef(name)
+		if err == errRefNotFound {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		return hash, nil
+	}
+	return "", errRefNotFound
+}
+
+// validGitRefName reports whether name is safe to use as a path of a ref
+// relative to the git directory. It's less strict than git check-ref-format.
+func validGitRefName(name string) bool {
+	if name == "" || strings.ContainsAny(name, "\\\x00") {
+		return false
+	}
+	for _, c := range strings.Split(name, "/") {
+		if c == "" || c == "." || c == ".." || strings.HasPrefix(c, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// isPseudoRefName reports whether name is a pseudoref like HEAD or FETCH_HEAD.
+func isPseudoRefName(name string) bool {
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && c != '_' {
+			return false
+		}
+	}
+	return strings.HasSuffix(name, "HEAD")
+}
+
+// readGitPackedRefs reads a packed-refs file. A missing file has no refs.
+func readGitPackedRefs(path string) (map[string]string, error) {
+	refs := make(map[string]string)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return refs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// E.g.:
+	//
+	// 	# pack-refs with: peeled fully-peeled sorted
+	// 	3c7f4e1d1e7ee9ff5bc4a0f4c6bd3d5e2b7e5a01 refs/heads/main
+	// 	5a4ba4c5c3e8a8c2e4b0e5fbd2d8f6f0d4a1c2b3 refs/tags/v1.0
+	// 	^3c7f4e1d1e7ee9ff5bc4a0f4c6bd3d5e2b7e5a01
+	for _, line := range strings.Split(string(b), "\n") {
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid packed-refs line %q", line)
+		}
+		refs[name] = hash
+	}
+	return refs, nil
+}
+
+// gitObjectType is the type of a git object.
+// Its values match the ones used in pack files.
+type gitObjectType uint8
+
+const (
+	gitCommit   gitObjectType = 1
+	gitTree     gitObjectType = 2
+	gitBlob     gitObjectType = 3
+	gitTag      gitObjectType = 4
+	gitOfsDelta gitObjectType = 6 // Only in pack files.
+	gitRefDelta gitObjectType = 7 // Only in pack files.
+)
+
+func (t gitObjectType) String() string {
+	switch t {
+	case gitCommit:
+		return "commit"
+	case gitTree:
+		return "tree"
+	case gitBlob:
+		return "blob"
+	case gitTag:
+		return "tag"
+	default:
+		return fmt.Sprintf("gitObjectType(%d)", uint8(t))
+	}
+}
+
+// gitObject is the type and content of a git object.
+type gitObject struct {
+	typ  gitObjectType
+	data []byte
+}
+
+// errObjectNotFound is returned when an object doesn't exist.
+var errObjectNotFound = errors.New("object not found")
+
+// readObject reads the object with the given hash.
+// It returns errObjectNotFound if the object doesn't exist.
+func (r *gitRepo) readObject(hash string) (gitObject, error) {
+	if len(hash) != 2*r.hashSize || !isHex(hash) {
+		return gitObject{}, fmt.Errorf("invalid object hash %q", hash)
+	}
+	for _, dir := range r.objectDirs {
+		o, err := readGitLooseObject(filepath.Join(dir, hash[:2], hash[2:]))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return gitObject{}, fmt.Errorf("object %s: %v", hash, err)
+		}
+		return o, nil
+	}
+	if !r.loaded {
+		r.packs, r.packsErr = loadGitPacks(r.objectDirs, r.hashSize)
+		r.loaded = true
+	}
+	if r.packsErr != nil {
+		return gitObject{}, r.packsErr
+	}
+	raw, _ := hex.DecodeString(hash)
+	for _, p := range r.packs {
+		offset, ok := p.find(raw)
+		if !ok {
+			continue
+		}
+		o, err := r.readPacked(p, offset, 0)
+		if err != nil {
+			return gitObject{}, fmt.Errorf("object %s: %v", hash, err)
+		}
+		return o, nil
+	}
+	return gitObject{}, errObjectNotFound
+}
+
+// readGitLooseObject reads a loose object from the file at path.
+func readGitLooseObject(path string) (gitObject, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return gitObject{}, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return gitObject{}, err
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return gitObject{}, err
+	}
+	// E.g., "commit 234\x00tree ...".
+	header, data, ok := bytes.Cut(b, []byte{0})
+	if !ok {
+		return gitObject{}, errors.New("invalid loose object header")
+	}
+	typeName, size, ok := strings.Cut(string(header), " ")
+	if !ok || size != strconv.Itoa(len(data)) {
+		return gitObject{}, fmt.Errorf("invalid loose object header %q", header)
+	}
+	o := gitObject{data: data}
+	switch typeName {
+	case "commit":
+		o.typ = gitCommit
+	case "tree":
+		o.typ = gitTree
+	case "blob":
+		o.typ = gitBlob
+	case "tag":
+		o.typ = gitTag
+	default:
+		return gitObject{}, fmt.Errorf("unknown loose object type %q", typeName)
+	}
+	return o, nil
+}
+
+// gitPack is a pack file and its index.
+type gitPack struct {
+	path     string // Path of .pack file.
+	idx      []byte // Contents of .idx file.
+	n        int    // Number of objects.
+	hashSize int
+	f        *os.File // Opened on first use.
+}
+
+// gitPackOffset identifies an object in a pack by its offset.
+type gitPackOffset struct {
+	p      *gitPack
+	offset int64
+}
+
+// loadGitPacks loads the indexes of all pack files in objectDirs.
+func loadGitPacks(objectDirs []string, hashSize int) ([]*gitPack, error) {
+	var packs []*gitPack
+	for _, dir := range objectDirs {
+		idxs, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(idxs)
+		for _, idx := range idxs {
+			b, err := os.ReadFile(idx)
+			if err != nil {
+				return nil, err
+			}
+			p, err := parseGitPackIndex(b, hashSize)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", idx, err)
+			}
+			p.path = strings.TrimSuffix(idx, ".idx") + ".pack"
+			packs = append(packs, p)
+		}
+	}
+	return packs, nil
+}
+
+// Layout of a version 2 pack index:
+//
+//	magic and version           8 bytes
+//	fanout table                256 × 4 bytes
+//	object hashes, sorted       n × hashSize bytes
+//	CRC32 checksums             n × 4 bytes
+//	offsets                     n × 4 bytes
+//	large offsets               m × 8 bytes
+//	pack and index checksums    2 × hashSize bytes
+const (
+	gitPackIndexFanout = 8
+	gitPackIndexHashes = gitPackIndexFanout + 256*4
+)
+
+// parseGitPackIndex parses the contents of a version 2 pack index.
+func parseGitPackIndex(b []byte, hashSize int) (*gitPack, error) {
+	if len(b) < gitPackIndexHashes || !bytes.Equal(b[:8], []byte("\xfftOc\x00\x00\x00\x02")) {
+		return nil, errors.New("unsupported pack index version")
+	}
+	n := int(binary.BigEndian.Uint32(b[gitPackIndexHashes-4:]))
+	if len(b) < gitPackIndexHashes+n*(hashSize+4+4)+2*hashSize {
+		return nil, errors.New("pack index is truncated")
+	}
+	return &gitPack{idx: b, n: n, hashSize: hashSize}, nil
+}
+
+// find returns the offset in the pack of the object with hash raw, if it exists.
+func (p *gitPack) find(raw []byte) (offset int64, ok bool) {
+	lo := 0
+	if raw[0] > 0 {
+		lo = int(binary.BigEndian.Uint32(p.idx[gitPackIndexFanout+4*(int(raw[0])-1):]))
+	}
+	hi := int(binary.BigEndian.Uint32(p.idx[gitPackIndexFanout+4*int(raw[0]):]))
+	if lo > hi || hi > p.n {
+		return 0, false
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.hash(lo+i), raw) >= 0
+	})
+	if i == hi || !bytes.Equal(p.hash(i), raw) {
+		return 0, false
+	}
+	offsets := gitPackIndexHashes + p.n*(p.hashSize+4)
+	off := binary.BigEndian.Uint32(p.idx[offsets+4*i:])
+	if off&0x80000000 == 0 {
+		return int64(off), true
+	}
+	// The offset doesn't fit in 31 bits, so it's stored in the large offsets table.
+	large := offsets + p.n*4 + 8*int(off&0x7fffffff)
+	if large+8 > len(p.idx) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(p.idx[large:])), true
+}
+
+// hash returns the hash of the i-th object in the index.
+func (p *gitPack) hash(i int) []byte {
+	start := gitPackIndexHashes + i*p.hashSize
+	return p.idx[start : start+p.hashSize]
+}
+
+// maxGitDeltaDepth is a limit on the length of delta chains,
+// to protect against cycles in corrupt pack files.
+const maxGitDeltaDepth = 10000
+
+// readPacked reads the object at offset in pack p, resolving deltas.
+func (r *gitRepo) readPacked(p *gitPack, offset int64, depth int) (gitObject, error) {
+	if depth > maxGitDeltaDepth {
+		return gitObject{}, errors.New("delta chain is too long")
+	}
+	if p.f == nil {
+		f, err := os.Open(p.path)
+		if err != nil {
+			return gitObject{}, err
+		}
+		p.f = f
+	}
+	br := bufio.NewReader(io.NewSectionReader(p.f, offset, 1<<62))
+
+	// Read the entry header: type and size, then the delta base, if any.
+	c, err := br.ReadByte()
+	if err != nil {
+		return gitObject{}, err
+	}
+	typ, size := gitObjectType(c>>4&7), uint64(c&0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = br.ReadByte(); err != nil {
+			return gitObject{}, err
+		} else if shift > 57 {
+			return gitObject{}, errors.New("invalid pack entry size")
+		}
+		size |= uint64(c&0x7f) << shift
+	}
+	var base gitObject
+	switch typ {
+	case gitCommit, gitTree, gitBlob, gitTag:
+		data, err := inflateGit(br, size)
+		return gitObject{typ: typ, data: data}, err
+	case gitOfsDelta:
+		// The base is at a negative offset, encoded in a variant of base 128.
+		c, err := br.ReadByte()
+		if err != nil {
+			return gitObject{}, err
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return gitObject{}, err
+			} else if rel > 1<<55 {
+				return gitObject{}, errors.New("invalid delta base offset")
+			}
+			rel = (rel+1)<<7 | int64(c&0x7f)
+		}
+		if rel <= 0 || rel > offset {
+			return gitObject{}, errors.New("invalid delta base offset")
+		}
+		key := gitPackOffset{p, offset - rel}
+		var ok bool
+		if base, ok = r.deltaBases[key]; !ok {
+			base, err = r.readPacked(p, offset-rel, depth+1)
+			if err != nil {
+				return gitObject{}, err
+			}
+			r.cacheDeltaBase(key, base)
+		}
+	case gitRefDelta:
+		raw := make([]byte, r.hashSize)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return gitObject{}, err
+		}
+		base, err = r.readObject(hex.EncodeToString(raw))
+		if err != nil {
+			return gitObject{}, err
+		}
+	default:
+		return gitObject{}, fmt.Errorf("unknown pack entry type %d", typ)
+	}
+	delta, err := inflateGit(br, size)
+	if err != nil {
+		return gitObject{}, err
+	}
+	data, err := applyGitDelta(base.data, delta)
+	return gitObject{typ: base.typ, data: data}, err
+}
+
+// cacheDeltaBase caches delta base o, since it's likely to be
+// the base of other deltas too. The cache is reset when it gets large.
+func (r *gitRepo) cacheDeltaBase(key gitPackOffset, o gitObject) {
+	if r.deltaBases == nil || len(r.deltaBases) >= 256 {
+		r.deltaBases = make(map[gitPackOffset]gitObject)
+	}
+	r.deltaBases[key] = o
+}
+
+// inflateGit reads zlib-compressed data of the given uncompressed size.
+func inflateGit(r io.Reader, size uint64) ([]byte, error) {
+	if size > 1<<32 {
+		return nil, fmt.Errorf("object size %v is too large", size)
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(zr, data)
+	return data, err
+}
+
+// applyGitDelta applies delta to base, and returns the result.
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	baseSize, delta, err := readGitDeltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("delta base size is %v, want %v", len(base), baseSize)
+	}
+	size, delta, err := readGitDeltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, size)
+	for len(delta) > 0 {
+		c := delta[0]
+		delta = delta[1:]
+		switch {
+		case c&0x80 != 0:
+			// Copy from base. The low 4 bits select the present offset bytes,
+			// and the next 3 bits select the present size bytes.
+			var offset, n uint64
+			for i := 0; i < 7; i++ {
+				if c&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errors.New("truncated delta copy instruction")
+				}
+				if i < 4 {
+					offset |= uint64(delta[0]) << (8 * i)
+				} else {
+					n |= uint64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if offset+n > uint64(len(base)) {
+				return nil, errors.New("delta copy instruction is out of bounds")
+			}
+			out = append(out, base[offset:offset+n]...)
+		case c != 0:
+			// Insert the next c bytes of delta.
+			if int(c) > len(delta) {
+				return nil, errors.New("truncated delta insert instruction")
+			}
+			out = append(out, delta[:c]...)
+			delta = delta[c:]
+		default:
+			return nil, errors.New("invalid delta instruction")
+		}
+	}
+	if uint64(len(out)) != size {
+		return nil, fmt.Errorf("delta result size is %v, want %v", len(out), size)
+	}
+	return out, nil
+}
+
+// readGitDeltaSize reads a size from the header of a delta,
+// encoded in little-endian base 128.
+func readGitDeltaSize(delta []byte) (size uint64, rest []byte, err error) {
+	for shift := 0; ; shift += 7 {
+		if len(delta) == 0 || shift > 63 {
+			return 0, nil, errors.New("invalid delta header")
+		}
+		c := delta[0]
+		delta = delta[1:]
+		size |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return size, delta, nil
+		}
+	}
+}
+
+// peelToCommit follows tags starting at the object with the given hash,
+// and returns the hash and content of the commit they point to.
+func (r *gitRepo) peelToCommit(hash string) (string, gitObject, error) {
+	for i := 0; ; i++ {
+		o, err := r.readObject(hash)
+		if err != nil {
+			return "", gitObject{}, err
+		}
+		switch {
+		case o.typ == gitCommit:
+			return hash, o, nil
+		case o.typ == gitTag && i < 100:
+			hash, _ = gitObjectHeader(o.data, "object")
+		default:
+			return "", gitObject{}, fmt.Errorf("object %s is a %v, not a commit", hash, o.typ)
+		}
+	}
+}
+
+// gitObjectHeader returns the value of the first header with the given key
+// in the content of a commit or tag object.
+func gitObjectHeader(data []byte, key string) (string, bool) {
+	for _, line := range gitObjectHeaders(data) {
+		if k, v, _ := strings.Cut(line, " "); k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// gitObjectHeaders returns the header lines of a commit or tag object,
+// which precede the first empty line.
+func gitObjectHeaders(data []byte) []string {
+	header, _, _ := bytes.Cut(data, []byte("\n\n"))
+	return strings.Split(string(header), "\n")
+}
+
+// isAncestor reports whether the commit with hash ancestor
+// is reachable from the commit with hash tip, including tip itself.
+// It walks the whole history reachable from tip that isn't cut off by shallow
+// commits, without relying on commit dates, so clock skew between committers
+// doesn't affect the result.
+func (r *gitRepo) isAncestor(ctx context.Context, ancestor, tip string) (bool, error) {
+	if r.shallow == nil {
+		var err error
+		r.shallow, err = readGitShallow(filepath.Join(r.commonDir, "shallow"))
+		if err != nil {
+			return false, err
+		}
+	}
+	seen := map[string]bool{tip: true}
+	queue := []string{tip}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		hash := queue[0]
+		queue = queue[1:]
+		if hash == ancestor {
+			return true, nil
+		}
+		if r.shallow[hash] {
+			// Parents of shallow commits are not available.
+			continue
+		}
+		o, err := r.readObject(hash)
+		if err != nil {
+			return false, err
+		}
+		if o.typ != gitCommit {
+			return false, fmt.Errorf("object %s is a %v, not a commit", hash, o.typ)
+		}
+		for _, line := range gitObjectHeaders(o.data) {
+			if !strings.HasPrefix(line, "parent ") {
+				continue
+			}
+			parent := strings.TrimPrefix(line, "parent ")
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			queue = append(queue, parent)
+		}
+	}
+	return false, nil
+}
+
+// readGitShallow reads the set of shallow commits from a shallow file.
+// A missing file has no shallow commits.
+func readGitShallow(path string) (map[string]bool, error) {
+	shallow := make(map[string]bool)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return shallow, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, hash := range strings.Fields(string(b)) {
+		shallow[hash] = true
+	}
+	return shallow, nil
+}
+
+// isHex reports whether s consists of hexadecimal digits only.
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// gitConfig is git configuration, with entries in the order they were read.
+type gitConfig []gitConfigEntry
+
+// gitConfigEntry is a single configuration variable.
+type gitConfigEntry struct {
+	Key   string // E.g., "remote.origin.url". Section and variable names are lowercase.
+	Value string
+}
+
+// get returns the last value of the variable with the given key,
+// whose section and variable names must be lowercase.
+func (c gitConfig) get(key string) (string, bool) {
+	for i := len(c) - 1; i >= 0; i-- {
+		if c[i].Key == key {
+			return c[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// getAll returns all values of the variable with the given key,
+// whose section and variable names must be lowercase.
+func (c gitConfig) getAll(key string) []string {
+	var values []string
+	for _, e := range c {
+		if e.Key == key {
+			values = append(values, e.Value)
+		}
+	}
+	return values
+}
+
+// loadGitConfig loads the system, global and repository configuration, in that order,
+// from the same files as git does. Missing files are skipped. Includes are not supported.
+func loadGitConfig(repoConfig string) (gitConfig, error) {
+	var paths []string
+	if os.Getenv("GIT_CONFIG_NOSYSTEM") == "" {
+		if path := os.Getenv("GIT_CONFIG_SYSTEM"); path != "" {
+			paths = append(paths, path)
+		} else {
+			paths = append(paths, "/etc/gitconfig")
+		}
+	}
+	if path := os.Getenv("GIT_CONFIG_GLOBAL"); path != "" {
+		paths = append(paths, path)
+	} else {
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+			paths = append(paths, filepath.Join(xdg, "git", "config"))
+		} else if home, err := os.UserHomeDir(); err == nil {
+			paths = append(paths, filepath.Join(home, ".config", "git", "config"))
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			paths = append(paths, filepath.Join(home, ".gitconfig"))
+		}
+	}
+	paths = append(paths, repoConfig)
+
+	var c gitConfig
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		c, err = parseGitConfig(c, b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return c, nil
+}
+
+// parseGitConfig parses the contents of a git configuration file,
+// and appends its entries to c.
+func parseGitConfig(c gitConfig, b []byte) (gitConfig, error) {
+	s := string(b)
+	var section string
+	for i := 0; i < len(s); {
+		switch ch := s[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			i++
+		case ch == '#' || ch == ';':
+			i = skipLine(s, i)
+		case ch == '[':
+			// E.g., `[core]`, `[remote "origin"]`, or the deprecated `[branch.main]`.
+			end := strings.IndexAny(s[i:], " \t]\n")
+			if end == -1 || s[i+end] == '\n' {
+				return nil, fmt.Errorf("invalid section header at offset %d", i)
+			}
+			section = strings.ToLower(s[i+1 : i+end])
+			i += end
+			for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+				i++
+			}
+			if i < len(s) && s[i] == '"' {
+				var sub strings.Builder
+				for i++; i < len(s) && s[i] != '"'; i++ {
+					if s[i] == '\n' {
+						return nil, fmt.Errorf("invalid subsection at offset %d", i)
+					}
+					if s[i] == '\\' && i+1 < len(s) {
+						i++
+					}
+					sub.WriteByte(s[i])
+				}
+				section += "." + sub.String()
+				i++
+			}
+			if i >= len(s) || s[i] != ']' {
+				return nil, fmt.Errorf("invalid section header at offset %d", i)
+			}
+			i++
+		default:
+			// E.g., `url = https://example.com/repo`, or `bare` meaning true.
+			start := i
+			for i < len(s) && (isAlnum(s[i]) || s[i] == '-') {
+				i++
+			}
+			if i == start || section == "" {
+				return nil, fmt.Errorf("invalid variable at offset %d", start)
+			}
+			key := section + "." + strings.ToLower(s[start:i])
+			for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+				i++
+			}
+			value := "true"
+			if i < len(s) && s[i] == '=' {
+				var err error
+				value, i, err = parseGitConfigValue(s, i+1)
+				if err != nil {
+					return nil, err
+				}
+			} else if i < len(s) && s[i] != '\n' && s[i] != '\r' && s[i] != '#' && s[i] != ';' {
+				return nil, fmt.Errorf("invalid variable at offset %d", start)
+			}
+			c = append(c, gitConfigEntry{Key: key, Value: value})
+		}
+	}
+	return c, nil
+}
+
+// parseGitConfigValue parses a value that starts at s[i], and returns it
+// along with the offset of the end of its line.
+func parseGitConfigValue(s string, i int) (value string, end int, err error) {
+	var (
+		v       strings.Builder
+		space   int // Number of pending whitespace characters, written only if followed by more value.
+		spaceAt int
+		quoted  bool
+	)
+	for ; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '\n' && quoted:
+			return "", 0, fmt.Errorf("unterminated quoted value at offset %d", i)
+		case ch == '\n':
+			return v.String(), i, nil
+		case (ch == '#' || ch == ';') && !quoted:
+			return v.String(), skipLine(s, i), nil
+		case (ch == ' ' || ch == '\t' || ch == '\r') && !quoted:
+			if space == 0 {
+				spaceAt = i
+			}
+			space++
+		default:
+			if space > 0 && v.Len() > 0 {
+				v.WriteString(s[spaceAt : spaceAt+space])
+			}
+			space = 0
+			switch {
+			case ch == '"':
+				quoted = !quoted
+			case ch == '\\':
+				if i+1 >= len(s) {
+					return "", 0, fmt.Errorf("invalid escape at offset %d", i)
+				}
+				i++
+				switch s[i] {
+				case '\n':
+					// Line continuation.
+				case 'n':
+					v.WriteByte('\n')
+				case 't':
+					v.WriteByte('\t')
+				case 'b':
+					v.WriteByte('\b')
+				case '"', '\\':
+					v.WriteByte(s[i])
+				default:
+					return "", 0, fmt.Errorf("invalid escape at offset %d", i-1)
+				}
+			default:
+				v.WriteByte(ch)
+			}
+		}
+	}
+	if quoted {
+		return "", 0, errors.New("unterminated quoted value")
+	}
+	return v.String(), i, nil
+}
+
+// skipLine returns the offset of the newline that ends the line containing s[i],
+// or len(s) if it's the last line.
+func skipLine(s string, i int) int {
+	if n := strings.IndexByte(s[i:], '\n'); n != -1 {
+		return i + n
+	}
+	return len(s)
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,83 @@
+package vcsstate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyGitDelta(t *testing.T) {
+	base := []byte("hello, world")
+	delta := []byte{
+		12,      // Base size.
+		13,      // Result size.
+		0x90, 7, // Copy 7 bytes from base offset 0.
+		6, 'g', 'o', 'p', 'h', 'e', 'r', // Insert 6 bytes.
+	}
+	got, err := applyGitDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello, gopher"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, delta := range [][]byte{
+		{11, 13},            // Wrong base size.
+		{12, 5, 0x91, 8, 5}, // Copy out of bounds.
+		{12, 1, 0},          // Invalid instruction.
+		{12, 2, 1, 'a'},     // Wrong result size.
+	} {
+		if _, err := applyGitDelta(base, delta); err == nil {
+			t.Errorf("delta %v: got nil error, want non-nil", delta)
+		}
+	}
+}
+
+func TestParseGitConfig(t *testing.T) {
+	const config = `# Comment.
+[core]
+	bare = false
+	filemode
+[remote "origin"]
+	url = "https://example.com/a b" ; Comment.
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[Branch "Main"]
+	Remote = origin
+	description = first \
+second\tthird
+[url "https://example.com/"]
+	insteadOf = gh:
+`
+	got, err := parseGitConfig(nil, []byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := gitConfig{
+		{"core.bare", "false"},
+		{"core.filemode", "true"},
+		{"remote.origin.url", "https://example.com/a b"},
+		{"remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"},
+		{"branch.Main.remote", "origin"},
+		{"branch.Main.description", "first second\tthird"},
+		{"url.https://example.com/.insteadof", "gh:"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	r := gitRepo{config: got}
+	if got, want := r.rewriteURL("gh:user/repo"), "https://example.com/user/repo"; got != want {
+		t.Errorf("got rewritten URL %q, want %q", got, want)
+	}
+
+	for _, config := range []string{
+		"[core\n",
+		"key = value\n", // Outside of section.
+		"[core]\n\tkey = \"unterminated\n",
+		"[core]\n\tkey = \\x\n",
+	} {
+		if _, err := parseGitConfig(nil, []byte(config)); err == nil {
+			t.Errorf("%q: got nil error, want non-nil", config)
+		}
+	}
+}
@@ -1,3 +1,10 @@
 module github.com/shurcooL/vcsstate
 
 go 1.19
+
+require (
+	github.com/shurcooL/go v0.0.0-20200502201357-93f07166e636
+	golang.org/x/tools/go/vcs v0.1.0-deprecated
+)
+
+require golang.org/x/sys v0.9.0 // indirect
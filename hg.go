@@ -1,61 +1,146 @@
 package vcsstate
 
 import (
-	"errors"
+	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
 	"strings"
+	"sync"
 )
 
-var _, hgBinaryError = exec.LookPath("hg")
+// hgBinaries caches the results of looking up hg binaries, keyed by binary path.
+var hgBinaries = struct {
+	sync.Mutex
+	m map[string]error
+}{m: make(map[string]error)}
 
-type hg struct{}
+// lookHgBinary returns a non-nil error if the hg binary at path can't be found.
+// It looks up the binary only the first time it's called for a given path.
+// If runner is not nil, the binary may not be on this machine, so it's not looked up.
+func lookHgBinary(runner Runner, path string) error {
+	if runner != nil {
+		return nil
+	}
+	hgBinaries.Lock()
+	defer hgBinaries.Unlock()
+	if err, ok := hgBinaries.m[path]; ok {
+		return err
+	}
+	_, err := exec.LookPath(path)
+	hgBinaries.m[path] = err
+	return err
+}
+
+type hg struct {
+	hg             string               // Path to hg binary.
+	remoteContains HgRemoteContainsMode // Mode of RemoteContains, as in Options.HgRemoteContains.
+	runner         Runner               // Runner of hg commands, as in Options.Runner.
+}
 
-func (hg) Status(dir string) (string, error) {
-	cmd := exec.Command("hg", "status")
+func (h hg) StatusContext(ctx context.Context, dir string) (string, error) {
+	cmd := exec.Command(h.hg, "status")
 	cmd.Dir = dir
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, h.runner, cmd)
 	if err != nil {
 		return "", err
 	}
 	return string(out), nil
 }
 
-func (hg) Branch(dir string) (string, error) {
-	/* TODO: Detect and report detached head mode. This currently returns "default" even when in detached head mode.
+func (h hg) WorkingTreeStatusContext(ctx context.Context, dir string) ([]FileStatus, error) {
+	cmd := exec.Command(h.hg, "status", "--copies", "--ignored")
+	cmd.Dir = dir
 
-	Consider using `hg --debug identify` to resolve this. It might be helpful to detect detached head mode.
+	status, _, err := dividedOutput(ctx, h.runner, cmd)
+	if err != nil {
+		return nil, err
+	}
 
-		hg --debug identify
+	cmd = exec.Command(h.hg, "resolve", "--list")
+	cmd.Dir = dir
 
-		Print a summary identifying the repository state at REV using one or two parent hash identifiers,
-		followed by a "+" if the working directory has uncommitted changes, the branch name (if not default),
-		a list of tags, and a list of bookmarks.
+	resolve, _, err := dividedOutput(ctx, h.runner, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseHgStatus(status, resolve)
+}
 
-		65c40fd06bc50fdd6ded3a97b213f20d31428431
-		f5ac12b15e49095c60ae0acc6da0e28d47e2a29f+ tip
-		f5ac12b15e49095c60ae0acc6da0e28d47e2a29f tip
-	*/
-	cmd := exec.Command("hg", "branch")
+func (h hg) BranchContext(ctx context.Context, dir string) (string, error) {
+	// Mercurial always has a named branch checked out, so this reports it
+	// even if the working directory parent isn't a head. BranchInfo tells the two apart.
+	cmd := exec.Command(h.hg, "branch")
 	cmd.Dir = dir
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, h.runner, cmd)
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSuffix(string(out), "\n"), nil
 }
 
+func (h hg) BranchInfoContext(ctx context.Context, dir string) (BranchInfo, error) {
+	cmd := exec.Command(h.hg, "branch")
+	cmd.Dir = dir
+
+	out, _, err := dividedOutput(ctx, h.runner, cmd)
+	if err != nil {
+		return BranchInfo{}, err
+	}
+	info := BranchInfo{Name: strings.TrimSuffix(string(out), "\n")}
+
+	cmd = exec.Command(h.hg, "--debug", "identify", "-i")
+	cmd.Dir = dir
+
+	out, _, err = dividedOutput(ctx, h.runner, cmd)
+	if err != nil {
+		return BranchInfo{}, err
+	}
+	if len(out) < hgRevisionLength {
+		return BranchInfo{}, fmt.Errorf("output length %v is shorter than %v", len(out), hgRevisionLength)
+	}
+	if revision := string(out[:hgRevisionLength]); revision != hgNullRevision {
+		info.Revision = revision
+	} else {
+		info.State = Unborn
+		return info, nil
+	}
+
+	// "x" is just an arbitrary constant string that we look for in the output.
+	cmd = exec.Command(h.hg, "log", "--rev", ". and head()", "--template", "x")
+	cmd.Dir = dir
+
+	out, _, err = dividedOutput(ctx, h.runner, cmd)
+	if err != nil {
+		return BranchInfo{}, err
+	}
+	if string(out) != "x" {
+		// Working directory parent is not a head of its branch.
+		info.Name, info.State = "", Detached
+	}
+	return info, nil
+}
+
 // hgRevisionLength is the length of a Mercurial revision hash.
 const hgRevisionLength = 40
 
-func (hg) LocalRevision(dir string, defaultBranch string) (string, error) {
-	cmd := exec.Command("hg", "--debug", "identify", "-i", "--rev", defaultBranch)
+// hgNullRevision is the revision hash of the null changeset,
+// which is the working directory parent in an empty repository.
+const hgNullRevision = "0000000000000000000000000000000000000000"
+
+func (h hg) LocalRevisionContext(ctx context.Context, dir string, defaultBranch string) (string, error) {
+	cmd := exec.Command(h.hg, "--debug", "identify", "-i", "--rev", defaultBranch)
 	cmd.Dir = dir
 
-	out, err := cmd.Output()
-	if err != nil {
+	out, stderr, err := dividedOutput(ctx, h.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && bytes.Contains(stderr, []byte(fmt.Sprintf("unknown revision '%s'", defaultBranch))):
+		return "", fmt.Errorf("%w %q", ErrUnknownRevision, defaultBranch)
+	case err != nil:
 		return "", err
 	}
 	if len(out) < hgRevisionLength {
@@ -64,11 +149,11 @@ func (hg) LocalRevision(dir string, defaultBranch string) (string, error) {
 	return string(out[:hgRevisionLength]), nil
 }
 
-func (hg) Stash(dir string) (string, error) {
-	cmd := exec.Command("hg", "shelve", "--list")
+func (h hg) StashContext(ctx context.Context, dir string) (string, error) {
+	cmd := exec.Command(h.hg, "shelve", "--list")
 	cmd.Dir = dir
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, h.runner, cmd)
 	switch {
 	case err == nil && len(stdout) != 0:
 		return string(stdout), nil
@@ -81,75 +166,300 @@ func (hg) Stash(dir string) (string, error) {
 	}
 }
 
-func (hg) Contains(dir string, revision string, defaultBranch string) (bool, error) {
-	cmd := exec.Command("hg", "log", "--branch", defaultBranch, "--rev", revision)
+func (h hg) ContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
+	cmd := exec.Command(h.hg, "log", "--branch", defaultBranch, "--rev", revision)
 	cmd.Dir = dir
 
-	stdout, stderr, err := dividedOutput(cmd)
+	stdout, stderr, err := dividedOutput(ctx, h.runner, cmd)
 	switch {
 	case err == nil && len(stdout) != 0:
 		return true, nil // Non-zero output means this commit is indeed contained.
 	case err == nil && len(stdout) == 0:
 		return false, nil // Zero output means this commit is not contained.
-	case err != nil && string(stderr) == fmt.Sprintf("abort: unknown revision '%s'!\n", revision):
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("abort: unknown revision '%s'", revision))):
 		return false, nil // Unknown revision error means this commit is not contained.
 	default:
 		return false, err
 	}
 }
 
-func (hg) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
-	return false, errors.New("not implemented for hg")
+func (h hg) RemoteContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
+	var revset string
+	switch h.remoteContains {
+	case HgPhases:
+		revset = fmt.Sprintf("%q and public()", revision)
+	case HgOutgoing:
+		revset = fmt.Sprintf("%q and not outgoing()", revision)
+	default:
+		return false, fmt.Errorf("unknown HgRemoteContainsMode %v", h.remoteContains)
+	}
+	// Without a remote, nothing can be contained by it, even public commits.
+	if _, err := h.RemoteURLContext(ctx, dir); err != nil {
+		return false, err
+	}
+	cmd := exec.Command(h.hg, "log", "--branch", defaultBranch, "--rev", revset)
+	cmd.Dir = dir
+
+	stdout, stderr, err := dividedOutput(ctx, h.runner, cmd)
+	switch {
+	case err == nil && len(stdout) != 0:
+		return true, nil // Non-zero output means this commit is indeed contained.
+	case err == nil && len(stdout) == 0:
+		return false, nil // Zero output means this commit is not contained.
+	case err != nil && ctx.Err() != nil:
+		return false, err
+	case err != nil && bytes.HasPrefix(stderr, []byte(fmt.Sprintf("abort: unknown revision '%s'", revision))):
+		return false, nil // Unknown revision error means this commit is not contained.
+	case err != nil && h.remoteContains == HgOutgoing:
+		return false, remoteError(err, stderr)
+	default:
+		return false, err
+	}
 }
 
-func (hg) RemoteURL(dir string) (string, error) {
-	cmd := exec.Command("hg", "paths", "default")
+func (h hg) AheadBehindContext(ctx context.Context, dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error) {
+	if _, err := h.RemoteURLContext(ctx, dir); err != nil {
+		return 0, 0, "", err
+	}
+	// "x" is just an arbitrary constant string printed once per changeset, used for counting.
+	cmd := exec.Command(h.hg, "log", "--rev", fmt.Sprintf("outgoing() and ::%q", defaultBranch), "--template", "x")
 	cmd.Dir = dir
 
-	out, err := cmd.Output()
+	stdout, stderr, err := dividedOutput(ctx, h.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return 0, 0, "", err
+	case err != nil:
+		return 0, 0, "", remoteError(err, stderr)
+	}
+	ahead = len(stdout)
+
+	// Each incoming changeset is printed with its parents, e.g., "<node> <p1node> <p2node>\n".
+	cmd = exec.Command(h.hg, "incoming", "--quiet", "--branch", defaultBranch, "--template", "{node} {p1node} {p2node}\n", "default")
+	cmd.Dir = dir
+
+	stdout, stderr, err = dividedOutput(ctx, h.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return 0, 0, "", err
+	case err != nil && len(stdout) == 0 && len(stderr) == 0:
+		// Exit code 1 without output means there are no incoming changes.
+	case err != nil:
+		return 0, 0, "", remoteError(err, stderr)
+	}
+	incoming := make(map[string]bool)
+	var parents []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(stdout), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		nodes := strings.Fields(line)
+		incoming[nodes[0]] = true
+		parents = append(parents, nodes[1:]...)
+	}
+	behind = len(incoming)
+
+	// The remote default branch is known locally up to its boundary: the parents of
+	// incoming changesets that aren't incoming themselves, or its head if nothing is.
+	var boundary []string
+	for _, p := range parents {
+		if !incoming[p] && strings.Trim(p, "0") != "" {
+			boundary = append(boundary, p)
+		}
+	}
+	if behind == 0 {
+		remoteHead, err := hgRemoteRevision(ctx, h.runner, h.hg, dir, "default", defaultBranch)
+		switch {
+		case err == ErrUnknownRevision:
+			// The remote doesn't have the default branch, so there's no merge base.
+		case err != nil:
+			return 0, 0, "", err
+		default:
+			boundary = append(boundary, remoteHead)
+		}
+	}
+	if len(boundary) == 0 {
+		return ahead, behind, "", nil
+	}
+
+	// The merge base is the latest common ancestor of the local default branch
+	// and the boundary, like git merge-base picks one when there are several.
+	cmd = exec.Command(h.hg, "--debug", "log", "--rev", fmt.Sprintf("max(heads(::%q and ::(%s)))", defaultBranch, strings.Join(boundary, " + ")), "--template", "{node}")
+	cmd.Dir = dir
+
+	stdout, _, err = dividedOutput(ctx, h.runner, cmd)
 	if err != nil {
+		return 0, 0, "", err
+	}
+	return ahead, behind, string(stdout), nil
+}
+
+// HgRemoteContainsMode selects how RemoteContains is implemented for hg.
+// In either mode, if there's no remote, then ErrNoRemote is returned.
+type HgRemoteContainsMode uint8
+
+const (
+	// HgPhases makes RemoteContains work offline using phases.
+	// A commit is considered contained by the remote default branch if it's
+	// on the default branch and in the public phase. Commits become public
+	// when they're pushed to or pulled from a publishing repository (which is
+	// the default for Mercurial servers), so the result reflects the state of
+	// the remote as of the last push or pull.
+	HgPhases HgRemoteContainsMode = iota
+
+	// HgOutgoing makes RemoteContains query the remote using the outgoing() revset.
+	// A commit is considered contained by the remote default branch if it's
+	// on the default branch and it would not be pushed to the default push path.
+	// It reflects the current state of the remote, but requires network.
+	HgOutgoing
+)
+
+func (m HgRemoteContainsMode) String() string {
+	switch m {
+	case HgPhases:
+		return "phases"
+	case HgOutgoing:
+		return "outgoing"
+	default:
+		return fmt.Sprintf("HgRemoteContainsMode(%d)", uint8(m))
+	}
+}
+
+func (h hg) RemoteURLContext(ctx context.Context, dir string) (string, error) {
+	cmd := exec.Command(h.hg, "paths", "default")
+	cmd.Dir = dir
+
+	out, stderr, err := dividedOutput(ctx, h.runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && bytes.Contains(stderr, []byte("not found!")):
+		return "", ErrNoRemote // Older hg prints "not found!", newer hg "abort: not found!".
+	case err != nil:
 		return "", err
 	}
 	return strings.TrimSuffix(string(out), "\n"), nil
 }
 
-func (hg) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
-	// TODO: Query remote branch from actual remote; it's currently hardcoded to "default".
-	const defaultBranch = "default"
+func (h hg) RemoteHeadContext(ctx context.Context, dir string) (RemoteHead, error) {
+	// Check for a remote first, since hg identify reports a missing one
+	// the same way as a remote repository that can't be found.
+	if _, err := h.RemoteURLContext(ctx, dir); err != nil {
+		return RemoteHead{}, err
+	}
+	return hgRemoteHead(ctx, h.runner, h.hg, dir, "default")
+}
+
+func (hg) CachedRemoteDefaultBranchContext(ctx context.Context, dir string) (string, error) {
+	return "", fmt.Errorf("not implemented for hg, just use NoRemoteDefaultBranch")
+}
+
+func (h hg) GuessDefaultBranchContext(ctx context.Context, dir string) (branch string, source DefaultBranchSource, err error) {
+	// Mercurial doesn't have a configurable default branch name.
+	return h.NoRemoteDefaultBranch(), Builtin, nil
+}
 
-	cmd := exec.Command("hg", "--debug", "identify", "-i", "--rev", defaultBranch, "default")
+func (h hg) SnapshotContext(ctx context.Context, dir string) (Snapshot, error) {
+	// Mercurial has no upstream branches, so Upstream, Ahead and Behind are left empty.
+	return composeSnapshot(ctx, h, dir)
+}
+
+func (h hg) WorktreesContext(ctx context.Context, dir string) ([]Worktree, error) {
+	cmd := exec.Command(h.hg, "root")
 	cmd.Dir = dir
 
-	out, err := cmd.Output()
+	out, _, err := dividedOutput(ctx, h.runner, cmd)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	// Get the last line of output.
-	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") // lines will always contain at least one element.
-	return defaultBranch, lines[len(lines)-1], nil
+	w := Worktree{Path: strings.TrimSuffix(string(out), "\n"), Main: true}
+	w.Branch, err = h.BranchInfoContext(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return []Worktree{w}, nil
 }
 
-func (hg) CachedRemoteDefaultBranch() (string, error) {
-	return "", fmt.Errorf("not implemented for hg, just use NoRemoteDefaultBranch")
+func (hg) IsMainWorktreeContext(ctx context.Context, dir string) (bool, error) {
+	// A Mercurial repository has a single working directory.
+	return true, nil
 }
 
 func (hg) NoRemoteDefaultBranch() string {
 	return "default"
 }
 
-type remoteHg struct{}
-
-func (remoteHg) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
-	// TODO: Query remote branch from actual remote; it's currently hardcoded to "default".
-	const defaultBranch = "default"
+type remoteHg struct {
+	hg     string // Path to hg binary.
+	runner Runner // Runner of hg commands, as in Options.Runner.
+}
 
-	cmd := exec.Command("hg", "--debug", "identify", "-i", "--rev", defaultBranch, remoteURL)
+func (r remoteHg) RemoteHeadContext(ctx context.Context, remoteURL string) (RemoteHead, error) {
+	return hgRemoteHead(ctx, r.runner, r.hg, "", remoteURL)
+}
 
-	out, err := cmd.Output()
+// hgRemoteHead queries the default branch of source, which is a path name
+// such as "default" if dir is a repository, or a remote URL otherwise.
+// The "@" bookmark is what hg clone checks out if it exists,
+// so it's preferred over the tip of the "default" branch.
+func hgRemoteHead(ctx context.Context, runner Runner, hgPath, dir, source string) (RemoteHead, error) {
+	revision, err := hgRemoteRevision(ctx, runner, hgPath, dir, source, "@")
+	switch {
+	case err == nil:
+		branch, err := hgBookmarkBranch(ctx, runner, hgPath, dir, revision)
+		if err != nil {
+			return RemoteHead{}, err
+		}
+		return RemoteHead{Branch: branch, Revision: revision, Convention: Bookmark}, nil
+	case err != ErrUnknownRevision:
+		return RemoteHead{}, err
+	}
+	revision, err = hgRemoteRevision(ctx, runner, hgPath, dir, source, "default")
 	if err != nil {
-		return "", "", err
+		return RemoteHead{}, err
+	}
+	return RemoteHead{Branch: "default", Revision: revision, Convention: BranchTip}, nil
+}
+
+// hgBookmarkBranch returns the named branch of revision, which the "@" bookmark
+// of a remote points to. hg identify doesn't report it for remotes, so it's looked up
+// in the repository at dir. If dir is empty or doesn't have revision yet,
+// it falls back to "default".
+func hgBookmarkBranch(ctx context.Context, runner Runner, hgPath, dir, revision string) (string, error) {
+	if dir == "" {
+		return "default", nil
+	}
+	cmd := exec.Command(hgPath, "log", "--rev", revision, "--template", "{branch}")
+	cmd.Dir = dir
+
+	out, stderr, err := dividedOutput(ctx, runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && bytes.Contains(stderr, []byte(fmt.Sprintf("unknown revision '%s'", revision))):
+		return "default", nil // Revision isn't known locally.
+	case err != nil:
+		return "", err
+	}
+	return string(out), nil
+}
+
+// hgRemoteRevision returns the revision that rev resolves to in source.
+// It returns ErrUnknownRevision if source has no such revision.
+func hgRemoteRevision(ctx context.Context, runner Runner, hgPath, dir, source, rev string) (string, error) {
+	cmd := exec.Command(hgPath, "--debug", "identify", "-i", "--rev", rev, source)
+	cmd.Dir = dir
+
+	out, stderr, err := dividedOutput(ctx, runner, cmd)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return "", err
+	case err != nil && bytes.Contains(stderr, []byte(fmt.Sprintf("unknown revision '%s'", rev))):
+		return "", ErrUnknownRevision
+	case err != nil:
+		return "", remoteError(err, stderr)
 	}
 	// Get the last line of output.
 	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") // lines will always contain at least one element.
-	return defaultBranch, lines[len(lines)-1], nil
+	return lines[len(lines)-1], nil
 }
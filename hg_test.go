@@ -0,0 +1,35 @@
+package vcsstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestHgBookmarkBranch(t *testing.T) {
+	const revision = "0123456789abcdef0123456789abcdef01234567"
+	for _, tc := range []struct {
+		name    string
+		stdout  string
+		stderr  string
+		want    string
+		wantErr bool
+	}{
+		{name: "known", stdout: "stable", want: "stable"},
+		{name: "unknown revision", stderr: "abort: unknown revision '" + revision + "'!\n", want: "default"},
+		{name: "other failure", stderr: "abort: repository . not found!\n", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			runner := runnerFunc(func(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+				if tc.stderr != "" {
+					err = errors.New("exit status 255")
+				}
+				return []byte(tc.stdout), []byte(tc.stderr), err
+			})
+			got, err := hgBookmarkBranch(context.Background(), runner, "hg", "/repo", revision)
+			if (err != nil) != tc.wantErr || got != tc.want {
+				t.Errorf("got %q, %v, want %q and error %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+}
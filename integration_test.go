@@ -0,0 +1,663 @@
+package vcsstate_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/shurcooL/vcsstate"
+	"github.com/shurcooL/vcsstate/vcsstatetest"
+)
+
+// oldGitRunner runs commands locally, but reports an old git version,
+// so that the git 1.7 backend is selected even with a newer git binary.
+type oldGitRunner struct{}
+
+func (oldGitRunner) Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+	if len(args) == 1 && args[0] == "--version" {
+		return []byte("git version 1.9.5\n"), nil, nil
+	}
+	return vcsstate.ExecRunner{}.Run(ctx, program, args, dir, env)
+}
+
+// TestIntegration exercises VCS and RemoteVCS of each backend
+// with real repositories and file:// remotes on disk.
+func TestIntegration(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		kind   vcsstate.Kind
+		runner vcsstate.Runner
+	}{
+		{name: "git28", kind: vcsstate.Git},
+		{name: "git17", kind: vcsstate.Git, runner: oldGitRunner{}},
+		{name: "hg", kind: vcsstate.Hg},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var repos *vcsstatetest.TempRepos
+			switch tc.kind {
+			case vcsstate.Git:
+				repos = vcsstatetest.NewTempGitRepos(t)
+			case vcsstate.Hg:
+				repos = vcsstatetest.NewTempHgRepos(t)
+			}
+			testIntegration(t, tc.kind, repos, &vcsstate.Options{Runner: tc.runner})
+		})
+	}
+}
+
+func testIntegration(t *testing.T, kind vcsstate.Kind, repos *vcsstatetest.TempRepos, opt *vcsstate.Options) {
+	v, err := vcsstate.New(kind, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rv, err := vcsstate.NewRemote(kind, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultBranch := map[vcsstate.Kind]string{vcsstate.Git: "main", vcsstate.Hg: "default"}[kind]
+	push := func(dir string, remoteURL string) {
+		t.Helper()
+		switch kind {
+		case "git":
+			repos.Run(dir, "push", "--quiet", remoteURL, "main")
+		case "hg":
+			repos.Run(dir, "push", "--quiet", remoteURL)
+		}
+	}
+
+	remoteURL := repos.InitRemote("remote")
+	seed := repos.Init("seed")
+	first := repos.Commit(seed, "first")
+	push(seed, remoteURL)
+	dir := repos.Clone(remoteURL, "clone")
+
+	t.Run("clean", func(t *testing.T) {
+		if got, err := v.Status(dir); err != nil || got != "" {
+			t.Errorf("Status: got %q, %v, want empty", got, err)
+		}
+		if got, err := v.WorkingTreeStatus(dir); err != nil || len(got) != 0 {
+			t.Errorf("WorkingTreeStatus: got %+v, %v, want no entries", got, err)
+		}
+		if got, err := v.Branch(dir); err != nil || got != defaultBranch {
+			t.Errorf("Branch: got %q, %v, want %q", got, err, defaultBranch)
+		}
+		want := vcsstate.BranchInfo{Name: defaultBranch, Revision: first}
+		if got, err := v.BranchInfo(dir); err != nil || got != want {
+			t.Errorf("BranchInfo: got %+v, %v, want %+v", got, err, want)
+		}
+		if got, err := v.LocalRevision(dir, defaultBranch); err != nil || got != first {
+			t.Errorf("LocalRevision: got %q, %v, want %q", got, err, first)
+		}
+		if got, err := v.Stash(dir); err != nil || got != "" {
+			t.Errorf("Stash: got %q, %v, want empty", got, err)
+		}
+		if got, err := v.Contains(dir, first, defaultBranch); err != nil || !got {
+			t.Errorf("Contains: got %v, %v, want true", got, err)
+		}
+		if got, err := v.RemoteContains(dir, first, defaultBranch); err != nil || !got {
+			t.Errorf("RemoteContains: got %v, %v, want true", got, err)
+		}
+		if ahead, behind, mergeBase, err := v.AheadBehind(dir, defaultBranch); err != nil || ahead != 0 || behind != 0 || mergeBase != first {
+			t.Errorf("AheadBehind: got %v, %v, %q, %v, want 0, 0, %q", ahead, behind, mergeBase, err, first)
+		}
+		if got, err := v.RemoteURL(dir); err != nil || got != remoteURL {
+			t.Errorf("RemoteURL: got %q, %v, want %q", got, err, remoteURL)
+		}
+		if branch, revision, err := v.RemoteBranchAndRevision(dir); err != nil || branch != defaultBranch || revision != first {
+			t.Errorf("RemoteBranchAndRevision: got %q, %q, %v, want %q, %q", branch, revision, err, defaultBranch, first)
+		}
+		if got, err := v.RemoteHead(dir); err != nil || got.Branch != defaultBranch || got.Revision != first {
+			t.Errorf("RemoteHead: got %+v, %v, want %q, %q", got, err, defaultBranch, first)
+		}
+		switch got, err := v.CachedRemoteDefaultBranch(dir); {
+		case kind == "git" && (err != nil || got != defaultBranch):
+			t.Errorf("CachedRemoteDefaultBranch: got %q, %v, want %q", got, err, defaultBranch)
+		case kind == "hg" && err == nil:
+			t.Errorf("CachedRemoteDefaultBranch: got %q, nil, want error", got)
+		}
+		if got, _, err := v.GuessDefaultBranch(dir); err != nil || got != defaultBranch {
+			t.Errorf("GuessDefaultBranch: got %q, %v, want %q", got, err, defaultBranch)
+		}
+		if got, want := v.NoRemoteDefaultBranch(), map[vcsstate.Kind]string{vcsstate.Git: "master", vcsstate.Hg: "default"}[kind]; got != want {
+			t.Errorf("NoRemoteDefaultBranch: got %q, want %q", got, want)
+		}
+		s, err := v.Snapshot(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Branch != want || len(s.Files) != 0 || s.Stash || s.DefaultBranch != defaultBranch || s.LocalRevision != first {
+			t.Errorf("Snapshot: got %+v", s)
+		}
+		if wantUpstream := map[vcsstate.Kind]string{vcsstate.Git: "origin/main"}[kind]; s.Upstream != wantUpstream || s.Ahead != 0 || s.Behind != 0 {
+			t.Errorf("Snapshot: got upstream %q, %v, %v, want %q, 0, 0", s.Upstream, s.Ahead, s.Behind, wantUpstream)
+		}
+
+		if got, err := rv.RemoteHead(remoteURL); err != nil || got.Branch != defaultBranch || got.Revision != first {
+			t.Errorf("RemoteVCS.RemoteHead: got %+v, %v, want %q, %q", got, err, defaultBranch, first)
+		}
+		if branch, revision, err := rv.RemoteBranchAndRevision(remoteURL); err != nil || branch != defaultBranch || revision != first {
+			t.Errorf("RemoteVCS.RemoteBranchAndRevision: got %q, %q, %v, want %q, %q", branch, revision, err, defaultBranch, first)
+		}
+		if _, err := rv.RemoteHead(remoteURL + "-missing"); !errors.As(err, new(vcsstate.NotFoundError)) {
+			t.Errorf("RemoteVCS.RemoteHead: got error %v, want NotFoundError", err)
+		}
+	})
+
+	t.Run("unknown revisions", func(t *testing.T) {
+		for _, revision := range []string{"0123456789012345678901234567890123456789", "nonexistent"} {
+			if got, err := v.Contains(dir, revision, defaultBranch); err != nil || got {
+				t.Errorf("Contains(%q): got %v, %v, want false, nil", revision, got, err)
+			}
+			if got, err := v.RemoteContains(dir, revision, defaultBranch); err != nil || got {
+				t.Errorf("RemoteContains(%q): got %v, %v, want false, nil", revision, got, err)
+			}
+		}
+		if _, err := v.LocalRevision(dir, "nonexistent"); !errors.Is(err, vcsstate.ErrUnknownRevision) {
+			t.Errorf("LocalRevision: got error %v for unknown branch, want ErrUnknownRevision", err)
+		}
+		if kind == "git" {
+			if _, _, _, err := v.AheadBehind(dir, "nonexistent"); !errors.Is(err, vcsstate.ErrUnknownRevision) {
+				t.Errorf("AheadBehind: got error %v for unknown branch, want ErrUnknownRevision", err)
+			}
+		}
+	})
+
+	t.Run("dirty", func(t *testing.T) {
+		repos.WriteFile(dir, "untracked", "dirty")
+		if got, err := v.Status(dir); err != nil || got == "" {
+			t.Errorf("Status: got %q, %v, want non-empty", got, err)
+		}
+		want := []vcsstate.FileStatus{{Path: "untracked", Untracked: true}}
+		if got, err := v.WorkingTreeStatus(dir); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("WorkingTreeStatus: got %+v, %v, want %+v", got, err, want)
+		}
+		if s, err := v.Snapshot(dir); err != nil || !reflect.DeepEqual(s.Files, want) {
+			t.Errorf("Snapshot: got files %+v, %v, want %+v", s.Files, err, want)
+		}
+	})
+
+	t.Run("stash", func(t *testing.T) {
+		switch kind {
+		case "git":
+			repos.Run(dir, "stash", "--quiet", "--include-untracked")
+		case "hg":
+			repos.Run(dir, "shelve", "--quiet", "--addremove")
+		}
+		if got, err := v.Stash(dir); err != nil || got == "" {
+			t.Errorf("Stash: got %q, %v, want non-empty", got, err)
+		}
+		if got, err := v.Status(dir); err != nil || got != "" {
+			t.Errorf("Status: got %q, %v, want empty", got, err)
+		}
+		if s, err := v.Snapshot(dir); err != nil || !s.Stash {
+			t.Errorf("Snapshot: got stash %v, %v, want true", s.Stash, err)
+		}
+	})
+
+	t.Run("diverged", func(t *testing.T) {
+		second := repos.Commit(seed, "second")
+		push(seed, remoteURL)
+		local := repos.Commit(dir, "local")
+		if kind == "git" {
+			repos.Run(dir, "fetch", "--quiet")
+		}
+		if ahead, behind, mergeBase, err := v.AheadBehind(dir, defaultBranch); err != nil || ahead != 1 || behind != 1 || mergeBase != first {
+			t.Errorf("AheadBehind: got %v, %v, %q, %v, want 1, 1, %q", ahead, behind, mergeBase, err, first)
+		}
+		if got, err := v.Contains(dir, local, defaultBranch); err != nil || !got {
+			t.Errorf("Contains(local): got %v, %v, want true", got, err)
+		}
+		if got, err := v.Contains(dir, second, defaultBranch); err != nil || got {
+			t.Errorf("Contains(second): got %v, %v, want false", got, err)
+		}
+		if got, err := v.RemoteContains(dir, local, defaultBranch); err != nil || got {
+			t.Errorf("RemoteContains(local): got %v, %v, want false", got, err)
+		}
+		if got, err := v.RemoteHead(dir); err != nil || got.Revision != second {
+			t.Errorf("RemoteHead: got %+v, %v, want revision %q", got, err, second)
+		}
+		if kind == "git" {
+			if s, err := v.Snapshot(dir); err != nil || s.Upstream != "origin/main" || s.Ahead != 1 || s.Behind != 1 {
+				t.Errorf("Snapshot: got upstream %q, %v, %v, %v, want \"origin/main\", 1, 1", s.Upstream, s.Ahead, s.Behind, err)
+			}
+			// The remote-tracking branch was fetched, so it contains the remote commit.
+			if got, err := v.RemoteContains(dir, second, defaultBranch); err != nil || !got {
+				t.Errorf("RemoteContains(second): got %v, %v, want true", got, err)
+			}
+		}
+	})
+
+	t.Run("merged", func(t *testing.T) {
+		// Merge the remote default branch from "diverged" back in, then let the remote move on,
+		// so that the merge base is no longer the first commit.
+		second := repos.Run(seed, map[vcsstate.Kind][]string{
+			vcsstate.Git: {"rev-parse", "HEAD"},
+			vcsstate.Hg:  {"log", "--rev", ".", "--template", "{node}"},
+		}[kind]...)
+		switch kind {
+		case "git":
+			repos.Run(dir, "merge", "--quiet", "--strategy=ours", "--no-edit", "origin/main")
+		case "hg":
+			repos.Run(dir, "pull", "--quiet")
+			repos.Run(dir, "merge", "--quiet", "--tool=:local")
+			repos.Commit(dir, "merge")
+		}
+		repos.Commit(seed, "third")
+		push(seed, remoteURL)
+		if kind == "git" {
+			repos.Run(dir, "fetch", "--quiet")
+		}
+		if ahead, behind, mergeBase, err := v.AheadBehind(dir, defaultBranch); err != nil || ahead != 2 || behind != 1 || mergeBase != second {
+			t.Errorf("AheadBehind: got %v, %v, %q, %v, want 2, 1, %q", ahead, behind, mergeBase, err, second)
+		}
+	})
+
+	t.Run("gone upstream", func(t *testing.T) {
+		if kind != "git" {
+			t.Skip("hg has no upstream branches")
+		}
+		gone := repos.Clone(remoteURL, "gone")
+		repos.Run(gone, "update-ref", "-d", "refs/remotes/origin/main")
+		if s, err := v.Snapshot(gone); err != nil || s.Upstream != "origin/main" || s.Ahead != 0 || s.Behind != 0 {
+			t.Errorf("Snapshot: got upstream %q, %v, %v, %v, want \"origin/main\", 0, 0", s.Upstream, s.Ahead, s.Behind, err)
+		}
+	})
+
+	t.Run("detached", func(t *testing.T) {
+		switch kind {
+		case "git":
+			repos.Run(dir, "checkout", "--quiet", "--detach", first)
+		case "hg":
+			repos.Run(dir, "update", "--quiet", "--rev", first)
+		}
+		want := vcsstate.BranchInfo{State: vcsstate.Detached, Revision: first}
+		if got, err := v.BranchInfo(dir); err != nil || got != want {
+			t.Errorf("BranchInfo: got %+v, %v, want %+v", got, err, want)
+		}
+		if kind == "git" {
+			if got, err := v.Branch(dir); err != nil || got != "HEAD" {
+				t.Errorf("Branch: got %q, %v, want %q", got, err, "HEAD")
+			}
+		}
+		if s, err := v.Snapshot(dir); err != nil || s.Branch != want {
+			t.Errorf("Snapshot: got branch %+v, %v, want %+v", s.Branch, err, want)
+		}
+	})
+
+	t.Run("unborn", func(t *testing.T) {
+		unborn := repos.Init("unborn")
+		want := vcsstate.BranchInfo{State: vcsstate.Unborn, Name: defaultBranch}
+		if got, err := v.BranchInfo(unborn); err != nil || got != want {
+			t.Errorf("BranchInfo: got %+v, %v, want %+v", got, err, want)
+		}
+		if s, err := v.Snapshot(unborn); err != nil || s.Branch != want || s.LocalRevision != "" {
+			t.Errorf("Snapshot: got %+v, %v, want branch %+v", s, err, want)
+		}
+	})
+
+	t.Run("worktrees", func(t *testing.T) {
+		var worktree string
+		if kind == vcsstate.Git {
+			worktree = filepath.Join(repos.Root, "worktree")
+			repos.Run(dir, "worktree", "add", "--quiet", "-b", "feature", worktree, first)
+		}
+		worktrees, err := v.Worktrees(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []vcsstate.Worktree{{Path: dir, Main: true, Branch: vcsstate.BranchInfo{State: vcsstate.Detached, Revision: first}}}
+		if kind == vcsstate.Git {
+			want = append(want, vcsstate.Worktree{Path: worktree, Branch: vcsstate.BranchInfo{Name: "feature", Revision: first}})
+		}
+		if !reflect.DeepEqual(worktrees, want) {
+			t.Errorf("Worktrees: got %+v, want %+v", worktrees, want)
+		}
+		if got, err := v.IsMainWorktree(dir); err != nil || !got {
+			t.Errorf("IsMainWorktree: got %v, %v, want true", got, err)
+		}
+		if kind == vcsstate.Git {
+			if got, err := v.IsMainWorktree(worktree); err != nil || got {
+				t.Errorf("IsMainWorktree(worktree): got %v, %v, want false", got, err)
+			}
+		}
+	})
+
+	t.Run("no remote", func(t *testing.T) {
+		local := repos.Init("local")
+		revision := repos.Commit(local, "first")
+		if got, err := v.LocalRevision(local, defaultBranch); err != nil || got != revision {
+			t.Errorf("LocalRevision: got %q, %v, want %q", got, err, revision)
+		}
+		if _, err := v.RemoteURL(local); err != vcsstate.ErrNoRemote {
+			t.Errorf("RemoteURL: got error %v, want ErrNoRemote", err)
+		}
+		if _, _, err := v.RemoteBranchAndRevision(local); err != vcsstate.ErrNoRemote {
+			t.Errorf("RemoteBranchAndRevision: got error %v, want ErrNoRemote", err)
+		}
+		if _, err := v.RemoteHead(local); err != vcsstate.ErrNoRemote {
+			t.Errorf("RemoteHead: got error %v, want ErrNoRemote", err)
+		}
+		if _, err := v.RemoteContains(local, revision, defaultBranch); err != vcsstate.ErrNoRemote {
+			t.Errorf("RemoteContains: got error %v, want ErrNoRemote", err)
+		}
+		if _, _, _, err := v.AheadBehind(local, defaultBranch); err != vcsstate.ErrNoRemote {
+			t.Errorf("AheadBehind: got error %v, want ErrNoRemote", err)
+		}
+		if s, err := v.Snapshot(local); err != nil || s.LocalRevision != revision || s.Upstream != "" {
+			t.Errorf("Snapshot: got %+v, %v, want local revision %q", s, err, revision)
+		}
+	})
+}
+
+// TestScannerNoRemote checks that Scanner treats a repository without a remote
+// as an ordinary one when querying remotes, with each backend.
+func TestScannerNoRemote(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		kind   vcsstate.Kind
+		runner vcsstate.Runner
+	}{
+		{name: "git28", kind: vcsstate.Git},
+		{name: "git17", kind: vcsstate.Git, runner: oldGitRunner{}},
+		{name: "hg", kind: vcsstate.Hg},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var repos *vcsstatetest.TempRepos
+			switch tc.kind {
+			case vcsstate.Git:
+				repos = vcsstatetest.NewTempGitRepos(t)
+			case vcsstate.Hg:
+				repos = vcsstatetest.NewTempHgRepos(t)
+			}
+			local := repos.Init("local")
+			revision := repos.Commit(local, "first")
+
+			var got []vcsstate.RepoState
+			s := vcsstate.Scanner{Options: &vcsstate.Options{Runner: tc.runner}}
+			err := s.Scan(context.Background(), repos.Root, func(r vcsstate.RepoState) {
+				got = append(got, r)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d repositories, want 1", len(got))
+			}
+			r := got[0]
+			if r.Err != nil {
+				t.Fatalf("%s: %v", r.Root, r.Err)
+			}
+			if r.RemoteURL != "" || r.RemoteRevision != "" {
+				t.Errorf("got remote URL %q and revision %q, want both empty", r.RemoteURL, r.RemoteRevision)
+			}
+			if r.DefaultBranch != r.Branch || r.LocalRevision != revision {
+				t.Errorf("got default branch %q and local revision %q, want %q and %q", r.DefaultBranch, r.LocalRevision, r.Branch, revision)
+			}
+		})
+	}
+}
+
+// TestScannerMissingDefaultBranch checks that Scanner treats a repository
+// whose default branch from the remote doesn't exist locally as an ordinary one.
+func TestScannerMissingDefaultBranch(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		runner vcsstate.Runner
+	}{
+		{name: "git28"},
+		{name: "git17", runner: oldGitRunner{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repos := vcsstatetest.NewTempGitRepos(t)
+			remoteURL := repos.InitRemote("remote")
+			seed := repos.Clone(remoteURL, "seed")
+			revision := repos.Commit(seed, "first")
+			repos.Run(seed, "push", "--quiet", "origin", "HEAD:main")
+			local := repos.Clone(remoteURL, "local")
+			repos.Run(local, "checkout", "--quiet", "-b", "feature")
+			repos.Run(local, "branch", "--quiet", "-D", "main")
+
+			var got []vcsstate.RepoState
+			s := vcsstate.Scanner{Options: &vcsstate.Options{Runner: tc.runner}}
+			err := s.Scan(context.Background(), local, func(r vcsstate.RepoState) {
+				got = append(got, r)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d repositories, want 1", len(got))
+			}
+			r := got[0]
+			if r.Err != nil {
+				t.Fatalf("%s: %v", r.Root, r.Err)
+			}
+			if r.DefaultBranch != "main" || r.RemoteRevision != revision || r.LocalRevision != "" {
+				t.Errorf("got default branch %q, remote revision %q and local revision %q, want %q, %q and empty", r.DefaultBranch, r.RemoteRevision, r.LocalRevision, "main", revision)
+			}
+		})
+	}
+}
+
+// TestHgRemoteContains exercises RemoteContains with each HgRemoteContainsMode.
+func TestHgRemoteContains(t *testing.T) {
+	for _, mode := range []vcsstate.HgRemoteContainsMode{vcsstate.HgPhases, vcsstate.HgOutgoing} {
+		t.Run(mode.String(), func(t *testing.T) {
+			repos := vcsstatetest.NewTempHgRepos(t)
+			v, err := vcsstate.New(vcsstate.Hg, &vcsstate.Options{HgRemoteContains: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			remoteURL := repos.InitRemote("remote")
+			dir := repos.Clone(remoteURL, "clone")
+			pushed := repos.Commit(dir, "pushed")
+			repos.Run(dir, "push", "--quiet")
+			local := repos.Commit(dir, "local")
+			alone := repos.Init("alone")
+			aloneRevision := repos.Commit(alone, "alone")
+
+			if got, err := v.RemoteContains(dir, pushed, "default"); err != nil || !got {
+				t.Errorf("RemoteContains(pushed): got %v, %v, want true", got, err)
+			}
+			if got, err := v.RemoteContains(dir, local, "default"); err != nil || got {
+				t.Errorf("RemoteContains(local): got %v, %v, want false", got, err)
+			}
+			if _, err := v.RemoteContains(alone, aloneRevision, "default"); err != vcsstate.ErrNoRemote {
+				t.Errorf("RemoteContains(alone): got error %v, want ErrNoRemote", err)
+			}
+		})
+	}
+}
+
+// TestHgRemoteHeadBookmark checks that the "@" bookmark is reported
+// by the named branch it's on.
+func TestHgRemoteHeadBookmark(t *testing.T) {
+	repos := vcsstatetest.NewTempHgRepos(t)
+	v, err := vcsstate.New(vcsstate.Hg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rv, err := vcsstate.NewRemote(vcsstate.Hg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteURL := repos.InitRemote("remote")
+	seed := repos.Init("seed")
+	repos.Commit(seed, "first")
+	repos.Run(seed, "branch", "--quiet", "stable")
+	revision := repos.Commit(seed, "stable")
+	repos.Run(seed, "bookmark", "@")
+	repos.Run(seed, "push", "--quiet", "--new-branch", "--bookmark", "@", remoteURL)
+	dir := repos.Clone(remoteURL, "clone")
+
+	want := vcsstate.RemoteHead{Branch: "stable", Revision: revision, Convention: vcsstate.Bookmark}
+	if got, err := v.RemoteHead(dir); err != nil || got != want {
+		t.Errorf("RemoteHead: got %+v, %v, want %+v", got, err, want)
+	}
+	// Without a local repository, the branch can't be looked up.
+	want.Branch = "default"
+	if got, err := rv.RemoteHead(remoteURL); err != nil || got != want {
+		t.Errorf("RemoteVCS.RemoteHead: got %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestCachedRemoteDefaultBranch(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	remote := repos.Init("remote")
+	repos.Commit(remote, "first")
+	repos.Run(remote, "branch", "feature")
+	repos.Run("", "clone", "--quiet", "--origin=upstream", remote, "local")
+	local := filepath.Join(repos.Root, "local")
+	alone := repos.Init("alone")
+
+	for _, backend := range []struct {
+		name   string
+		runner vcsstate.Runner
+	}{
+		{"git28", nil},
+		{"git17", oldGitRunner{}},
+	} {
+		t.Run(backend.name, func(t *testing.T) {
+			newBackend := func(remote string) vcsstate.VCS {
+				return newGit(t, &vcsstate.Options{Remote: remote, Runner: backend.runner})
+			}
+			repos.Run(local, "remote", "set-head", "upstream", "main")
+			if got, err := newBackend("upstream").CachedRemoteDefaultBranch(local); err != nil || got != "main" {
+				t.Errorf("got %q, %v, want %q", got, err, "main")
+			}
+			// The remote HEAD is not cached for "origin", which doesn't exist.
+			if got, err := newBackend("").CachedRemoteDefaultBranch(local); err == nil {
+				t.Errorf("got %q, nil, want error for remote without cached HEAD", got)
+			}
+
+			repos.Run(local, "remote", "set-head", "upstream", "feature")
+			if got, err := newBackend("upstream").CachedRemoteDefaultBranch(local); err != nil || got != "feature" {
+				t.Errorf("got %q, %v, want %q", got, err, "feature")
+			}
+
+			repos.Run(local, "remote", "set-head", "upstream", "--delete")
+			if got, err := newBackend("upstream").CachedRemoteDefaultBranch(local); err == nil {
+				t.Errorf("got %q, nil, want error after remote HEAD was deleted", got)
+			}
+
+			if _, err := newBackend(vcsstate.UpstreamRemote).CachedRemoteDefaultBranch(alone); err != vcsstate.ErrNoRemote {
+				t.Errorf("got error %v, want ErrNoRemote", err)
+			}
+		})
+	}
+}
+
+// git24Runner runs commands locally, but makes git behave like git 2.4,
+// which has no git worktree command and doesn't know --git-common-dir.
+// It reports that version, so the git 1.7 backend is selected.
+type git24Runner struct{}
+
+func (git24Runner) Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+	switch {
+	case len(args) == 1 && args[0] == "--version":
+		return []byte("git version 2.4.0\n"), nil, nil
+	case len(args) > 0 && args[0] == "worktree":
+		return nil, []byte("git: 'worktree' is not a git command. See 'git --help'.\n"), errors.New("exit status 1")
+	case reflect.DeepEqual(args, []string{"rev-parse", "--git-dir", "--git-common-dir"}):
+		return []byte(".git\n--git-common-dir\n"), nil, nil
+	}
+	return vcsstate.ExecRunner{}.Run(ctx, program, args, dir, env)
+}
+
+func TestGit17WorktreesOldGit(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	dir := repos.Init("repo")
+	revision := repos.Commit(dir, "first")
+	root := repos.Run(dir, "rev-parse", "--show-toplevel")
+
+	v := newGit(t, &vcsstate.Options{Runner: git24Runner{}})
+	got, err := v.Worktrees(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []vcsstate.Worktree{{Path: root, Main: true, Branch: vcsstate.BranchInfo{Name: "main", Revision: revision}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Worktrees: got %+v, want %+v", got, want)
+	}
+	if main, err := v.IsMainWorktree(dir); err != nil || !main {
+		t.Errorf("IsMainWorktree: got %v, %v, want true", main, err)
+	}
+}
+
+// gitHTTPBackend returns a handler that serves the repositories in repos
+// using git http-backend.
+func gitHTTPBackend(t *testing.T, repos *vcsstatetest.TempRepos) http.Handler {
+	execPath := repos.Run("", "--exec-path")
+	backend := filepath.Join(execPath, "git-http-backend")
+	if _, err := exec.LookPath(backend); err != nil {
+		t.Skip("git http-backend not available:", err)
+	}
+	return &cgi.Handler{
+		Path:   backend,
+		Env:    []string{"GIT_PROJECT_ROOT=" + repos.Root, "GIT_HTTP_EXPORT_ALL=1", "GIT_CONFIG_NOSYSTEM=1"},
+		Stderr: io.Discard,
+	}
+}
+
+func TestRemoteGitHTTP(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	repo := repos.Init("repo")
+	repos.Run(repo, "symbolic-ref", "HEAD", "refs/heads/trunk")
+	revision := repos.Commit(repo, "first")
+	repos.Run(repo, "branch", "other")
+	repos.Run(repo, "tag", "-a", "-m", "tag", "v1")
+	want := vcsstate.RemoteHead{Branch: "trunk", Revision: revision, Convention: vcsstate.SymbolicRef}
+
+	backend := gitHTTPBackend(t, repos)
+	var posts int
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"v2", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost {
+				posts++
+			}
+			backend.ServeHTTP(w, req)
+		})},
+		{"v0", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req.Header.Del("Git-Protocol")
+			backend.ServeHTTP(w, req)
+		})},
+		{"v1", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req.Header.Set("Git-Protocol", "version=1")
+			backend.ServeHTTP(w, req)
+		})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(tc.handler)
+			defer ts.Close()
+			rv, err := vcsstate.NewRemote(vcsstate.Git, &vcsstate.Options{NativeGit: true, HTTPClient: ts.Client()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := rv.RemoteHead(ts.URL + "/repo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+			_, err = rv.RemoteHead(ts.URL + "/missing")
+			if !errors.As(err, new(vcsstate.NotFoundError)) {
+				t.Errorf("got error %v, want NotFoundError", err)
+			}
+		})
+	}
+	if posts == 0 {
+		t.Error("ls-refs command of protocol version 2 was not used")
+	}
+}
@@ -0,0 +1,49 @@
+package vcsstate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Kind is a kind of version control system, named by its command.
+type Kind string
+
+// Kinds of version control systems supported by New and NewRemote.
+const (
+	Git Kind = "git"
+	Hg  Kind = "hg"
+)
+
+// kindNames are the names of kinds of version control systems,
+// as in golang.org/x/tools/go/vcs, used in error messages.
+var kindNames = map[Kind]string{
+	Git:      "Git",
+	Hg:       "Mercurial",
+	"svn":    "Subversion",
+	"bzr":    "Bazaar",
+	"fossil": "Fossil",
+}
+
+// kinds are the supported kinds, in the order DetectKind looks for them.
+var kinds = []Kind{Git, Hg}
+
+// KindOf returns the Kind of cmd, for callers of golang.org/x/tools/go/vcs.
+func KindOf(cmd *vcs.Cmd) Kind {
+	return Kind(cmd.Cmd)
+}
+
+// DetectKind reports the kind of the repository rooted at dir.
+// A directory containing .git (a directory, or a file as in linked
+// worktrees and submodules) is a git repository, and one containing
+// .hg is an hg repository. It doesn't look in parent directories of dir.
+func DetectKind(dir string) (Kind, error) {
+	for _, kind := range kinds {
+		if _, err := os.Stat(filepath.Join(dir, "."+string(kind))); err == nil {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not the root of a git or hg repository", dir)
+}
@@ -0,0 +1,56 @@
+package vcsstate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestDetectKind(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"git/.git", "hg/.hg", "plain/.svn"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A linked worktree has a .git file instead of a directory.
+	if err := os.MkdirAll(filepath.Join(root, "worktree"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "worktree", ".git"), []byte("gitdir: ../git/.git/worktrees/worktree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		dir  string
+		want Kind
+	}{
+		{"git", Git},
+		{"worktree", Git},
+		{"hg", Hg},
+		{"git/.git", ""},
+		{"plain", ""},
+		{"nonexistent", ""},
+	} {
+		got, err := DetectKind(filepath.Join(root, tc.dir))
+		if got != tc.want || (err == nil) != (tc.want != "") {
+			t.Errorf("DetectKind(%q): got %q, %v, want %q", tc.dir, got, err, tc.want)
+		}
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	if got := KindOf(vcs.ByCmd("git")); got != Git {
+		t.Errorf("got %q, want %q", got, Git)
+	}
+	if got := KindOf(vcs.ByCmd("hg")); got != Hg {
+		t.Errorf("got %q, want %q", got, Hg)
+	}
+	_, err := New(KindOf(vcs.ByCmd("svn")), nil)
+	if got, want := fmt.Sprint(err), "Subversion (svn) support not implemented"; got != want {
+		t.Errorf("got error %q, want %q", got, want)
+	}
+}
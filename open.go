@@ -0,0 +1,68 @@
+package vcsstate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoRepository is the error used when no repository contains a path given to Open.
+var ErrNoRepository = errors.New("no git or hg repository found")
+
+// Repository is a repository found by Open.
+type Repository struct {
+	Root string // Repository root directory, i.e., the top of its working tree.
+	Kind Kind   // Kind of version control system.
+	VCS  VCS    // VCS to use with Root.
+}
+
+// Open finds the repository that contains path, which may be the repository
+// root directory, or any file or directory inside it, and creates a VCS
+// for it configured by opt, as New does. It walks up from path, and the first
+// directory that is the root of a repository, as reported by DetectKind, wins.
+// So for a linked git worktree or a submodule, whose .git is a file,
+// the root is that of the worktree or submodule, not its parent repository.
+// If no repository is found, an error wrapping ErrNoRepository is returned.
+func Open(path string, opt *Options) (Repository, error) {
+	root, kind, err := findRoot(path)
+	if err != nil {
+		return Repository{}, err
+	}
+	v, err := New(kind, opt)
+	if err != nil {
+		return Repository{}, err
+	}
+	return Repository{Root: root, Kind: kind, VCS: v}, nil
+}
+
+// findRoot finds the root directory and kind of the repository that contains path.
+func findRoot(path string) (root string, kind Kind, err error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if !fi.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		if kind, err := DetectKind(dir); err == nil {
+			if kind == Git {
+				// Check that a .git file points to a git directory, e.g., "gitdir: ../.git/worktrees/name".
+				if _, err := findGitDir(dir); err != nil {
+					return "", "", err
+				}
+			}
+			return dir, kind, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", fmt.Errorf("%s: %w", path, ErrNoRepository)
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,82 @@
+package vcsstate_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shurcooL/vcsstate"
+	"github.com/shurcooL/vcsstate/vcsstatetest"
+)
+
+func TestOpen(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	main := repos.Init("main")
+	if err := os.MkdirAll(filepath.Join(main, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	repos.WriteFile(filepath.Join(main, "a"), "file", "content")
+	revision := repos.Commit(main, "first")
+	worktree := filepath.Join(repos.Root, "worktree")
+	repos.Run(main, "worktree", "add", "--quiet", "--detach", worktree)
+	if err := os.Mkdir(filepath.Join(worktree, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{main, main},
+		{filepath.Join(main, "a", "b"), main},
+		{filepath.Join(main, "a", "file"), main},
+		{worktree, worktree},
+		{filepath.Join(worktree, "sub"), worktree},
+	} {
+		r, err := vcsstate.Open(tc.path, nil)
+		if err != nil {
+			t.Errorf("Open(%q): %v", tc.path, err)
+			continue
+		}
+		if r.Root != tc.want || r.Kind != vcsstate.Git {
+			t.Errorf("Open(%q): got root %q, kind %q, want %q, %q", tc.path, r.Root, r.Kind, tc.want, vcsstate.Git)
+		}
+		if got, err := r.VCS.LocalRevision(r.Root, "main"); err != nil || got != revision {
+			t.Errorf("Open(%q): LocalRevision: got %q, %v, want %q", tc.path, got, err, revision)
+		}
+	}
+
+	// An invalid .git file is an error, rather than a reason to keep looking.
+	invalid := filepath.Join(main, "invalid")
+	if err := os.Mkdir(invalid, 0755); err != nil {
+		t.Fatal(err)
+	}
+	repos.WriteFile(invalid, ".git", "not a gitdir")
+	if _, err := vcsstate.Open(invalid, nil); err == nil || errors.Is(err, vcsstate.ErrNoRepository) {
+		t.Errorf("Open(%q): got error %v, want invalid .git file error", invalid, err)
+	}
+
+	notRepo := t.TempDir()
+	if _, err := vcsstate.Open(notRepo, nil); !errors.Is(err, vcsstate.ErrNoRepository) {
+		t.Errorf("Open(%q): got error %v, want ErrNoRepository", notRepo, err)
+	}
+	if _, err := vcsstate.Open(filepath.Join(main, "nonexistent"), nil); err == nil {
+		t.Error("Open: got nil error for nonexistent path, want non-nil")
+	}
+}
+
+func TestOpenHg(t *testing.T) {
+	repos := vcsstatetest.NewTempHgRepos(t)
+	dir := repos.Init("repo")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	r, err := vcsstate.Open(filepath.Join(dir, "sub"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Root != dir || r.Kind != vcsstate.Hg {
+		t.Errorf("got root %q, kind %q, want %q, %q", r.Root, r.Kind, dir, vcsstate.Hg)
+	}
+}
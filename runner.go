@@ -0,0 +1,37 @@
+package vcsstate
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+)
+
+// Runner runs the git and hg commands used by VCS and RemoteVCS.
+// A custom Runner can log commands, run them elsewhere (e.g., over SSH
+// or inside a container), or substitute canned output in tests.
+// A Runner must be safe for concurrent use by multiple goroutines.
+type Runner interface {
+	// Run runs program with args in dir, using env as its environment,
+	// and returns its standard output and standard error. Like for exec.Cmd,
+	// an empty dir means the current directory, and a nil env means the
+	// current environment. If the program exits with a non-zero exit code,
+	// Run returns a non-nil error. If ctx is done before the program exits,
+	// Run should stop it.
+	Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error)
+}
+
+// ExecRunner is a Runner that runs commands on the local machine using os/exec.
+// It's used when Options.Runner is nil.
+type ExecRunner struct{}
+
+// Run implements Runner. The program is killed if ctx is done before it exits.
+func (ExecRunner) Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	var outb, errb bytes.Buffer
+	cmd.Stdout = &outb
+	cmd.Stderr = &errb
+	err = cmd.Run()
+	return outb.Bytes(), errb.Bytes(), err
+}
@@ -0,0 +1,113 @@
+package vcsstate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRunner is a Runner that returns canned output, keyed by program and args
+// joined with spaces, and records the commands it was asked to run.
+type fakeRunner struct {
+	outputs map[string]string
+
+	mu   sync.Mutex
+	cmds []fakeCommand
+}
+
+type fakeCommand struct {
+	command string // Program and args joined with spaces.
+	dir     string
+	env     []string
+}
+
+func (r *fakeRunner) Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+	command := strings.Join(append([]string{program}, args...), " ")
+	r.mu.Lock()
+	r.cmds = append(r.cmds, fakeCommand{command: command, dir: dir, env: env})
+	r.mu.Unlock()
+	out, ok := r.outputs[command]
+	if !ok {
+		return nil, []byte("unexpected command\n"), errors.New("exit status 1")
+	}
+	return []byte(out), nil, nil
+}
+
+func TestRunner(t *testing.T) {
+	r := &fakeRunner{outputs: map[string]string{
+		"/fake/git --version":                   "git version 2.40.0\n",
+		"/fake/git status --porcelain":          " M a.go\n",
+		"/fake/git rev-parse --abbrev-ref HEAD": "main\n",
+		"/fake/hg branch":                       "default\n",
+	}}
+	v, err := New(Git, &Options{GitPath: "/fake/git", Runner: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := v.Status("/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := " M a.go\n"; status != want {
+		t.Errorf("got status %q, want %q", status, want)
+	}
+	branch, err := v.Branch("/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "main"; branch != want {
+		t.Errorf("got branch %q, want %q", branch, want)
+	}
+	if _, err := v.Stash("/repo"); err == nil {
+		t.Error("got nil error for command without canned output, want non-nil")
+	}
+
+	// The hg binary is not looked up, since it's run by the Runner.
+	v, err = New(Hg, &Options{HgPath: "/fake/hg", Runner: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch, err = v.Branch("/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "default"; branch != want {
+		t.Errorf("got branch %q, want %q", branch, want)
+	}
+
+	var got []string
+	for _, c := range r.cmds {
+		got = append(got, c.command)
+		if c.command == "/fake/git --version" {
+			continue
+		}
+		if c.dir != "/repo" {
+			t.Errorf("%s: got dir %q, want %q", c.command, c.dir, "/repo")
+		}
+		if strings.HasPrefix(c.command, "/fake/git ") && !contains(c.env, "LANG=en_US.UTF-8") {
+			t.Errorf("%s: env doesn't contain LANG=en_US.UTF-8", c.command)
+		}
+	}
+	want := []string{
+		"/fake/git --version",
+		"/fake/git status --porcelain",
+		"/fake/git rev-parse --abbrev-ref HEAD",
+		"/fake/git stash list",
+		"/fake/hg branch",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got commands %q, want %q", got, want)
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package vcsstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// Scanner discovers repositories under a root directory and queries their state,
+// using bounded parallelism. The zero value is ready to use.
+type Scanner struct {
+	// Options is used to create a VCS for each repository found.
+	Options *Options
+
+	// LocalConcurrency is the maximum number of repositories queried
+	// locally at the same time. If zero, runtime.NumCPU() is used.
+	LocalConcurrency int
+
+	// RemoteConcurrency is the maximum number of repositories whose remotes
+	// are queried over the network at the same time. If zero, 8 is used.
+	RemoteConcurrency int
+
+	// Offline disables querying remotes. When set, RepoState.DefaultBranch
+	// comes from CachedRemoteDefaultBranch or GuessDefaultBranch, and
+	// RepoState.RemoteRevision is left empty.
+	Offline bool
+}
+
+// RepoState is the state of a repository found by Scanner.
+type RepoState struct {
+	Root string // Repository root directory.
+	VCS  VCS    // VCS used to query the repository. Nil if it couldn't be created.
+
+	Branch         string // Locally checked out branch.
+	Status         string // Status of working directory, as returned by VCS.Status.
+	Stash          string // Stash, as returned by VCS.Stash.
+	RemoteURL      string // Primary remote URL. Empty if there's no remote.
+	DefaultBranch  string // Default branch, from the remote if it was queried.
+	RemoteRevision string // Latest revision of remote default branch. Empty if remote wasn't queried.
+	LocalRevision  string // Latest revision of local default branch. Empty if it doesn't exist locally.
+
+	// Err is the first error encountered while querying the repository, if any.
+	// Fields that weren't queried because of it are left empty.
+	Err error
+}
+
+// Scan discovers repositories under root, queries their state, and calls fn
+// with the state of each repository as soon as it's available. Calls to fn are
+// made sequentially from the goroutine that called Scan, in no particular order.
+//
+// A directory containing .git (a directory or file) or .hg is a repository.
+// Scan doesn't look for repositories nested inside other repositories.
+// It returns after all discovered repositories have been passed to fn,
+// with the first error encountered walking root, if any.
+func (s *Scanner) Scan(ctx context.Context, root string, fn func(RepoState)) error {
+	localN, remoteN := s.LocalConcurrency, s.RemoteConcurrency
+	if localN <= 0 {
+		localN = runtime.NumCPU()
+	}
+	if remoteN <= 0 {
+		remoteN = 8
+	}
+	local, remote := make(chan struct{}, localN), make(chan struct{}, remoteN)
+
+	var (
+		results = make(chan RepoState)
+		walkErr = make(chan error, 1)
+	)
+	go func() {
+		var wg sync.WaitGroup
+		walkErr <- discoverRepos(ctx, root, func(dir string, kind Kind) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results <- s.query(ctx, dir, kind, local, remote)
+			}()
+		})
+		wg.Wait()
+		close(results)
+	}()
+	for r := range results {
+		fn(r)
+	}
+	return <-walkErr
+}
+
+// discoverRepos walks root and calls found for each repository.
+// It doesn't descend into repositories.
+func discoverRepos(ctx context.Context, root string, found func(dir string, kind Kind)) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if kind, err := DetectKind(path); err == nil {
+			found(path, kind)
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+// query queries the state of the repository rooted at dir, holding a token from local
+// while running local commands, and a token from remote while using the network.
+func (s *Scanner) query(ctx context.Context, dir string, kind Kind, local, remote chan struct{}) RepoState {
+	r := RepoState{Root: dir}
+
+	// Query local state.
+	if err := acquire(ctx, local); err != nil {
+		r.Err = err
+		return r
+	}
+	r.Err = s.queryLocal(ctx, &r, kind)
+	<-local
+	if r.Err != nil {
+		return r
+	}
+
+	// Query remote state.
+	if !s.Offline && r.RemoteURL != "" {
+		if err := acquire(ctx, remote); err != nil {
+			r.Err = err
+			return r
+		}
+		r.DefaultBranch, r.RemoteRevision, r.Err = r.VCS.RemoteBranchAndRevisionContext(ctx, dir)
+		<-remote
+		if r.Err != nil {
+			r.Err = fmt.Errorf("RemoteBranchAndRevision: %w", r.Err)
+			return r
+		}
+	}
+
+	// Query local revision of default branch, which may have come from remote.
+	if err := acquire(ctx, local); err != nil {
+		r.Err = err
+		return r
+	}
+	var err error
+	r.LocalRevision, err = r.VCS.LocalRevisionContext(ctx, dir, r.DefaultBranch)
+	<-local
+	if err != nil && !errors.Is(err, ErrUnknownRevision) {
+		r.Err = fmt.Errorf("LocalRevision: %w", err)
+	}
+	return r
+}
+
+// queryLocal queries the state of repository r that doesn't require network.
+func (s *Scanner) queryLocal(ctx context.Context, r *RepoState, kind Kind) error {
+	v, err := New(kind, s.Options)
+	if err != nil {
+		return err
+	}
+	r.VCS = v
+
+	r.Branch, err = v.BranchContext(ctx, r.Root)
+	if err != nil {
+		return fmt.Errorf("Branch: %w", err)
+	}
+	r.Status, err = v.StatusContext(ctx, r.Root)
+	if err != nil {
+		return fmt.Errorf("Status: %w", err)
+	}
+	r.Stash, err = v.StashContext(ctx, r.Root)
+	if err != nil {
+		return fmt.Errorf("Stash: %w", err)
+	}
+	r.RemoteURL, err = v.RemoteURLContext(ctx, r.Root)
+	if err != nil && err != ErrNoRemote {
+		return fmt.Errorf("RemoteURL: %w", err)
+	}
+
+	if !s.Offline && r.RemoteURL != "" {
+		// Default branch will come from the remote.
+		return nil
+	}
+	if r.RemoteURL != "" {
+		if r.DefaultBranch, err = v.CachedRemoteDefaultBranchContext(ctx, r.Root); err == nil {
+			return nil
+		}
+	}
+	r.DefaultBranch, _, err = v.GuessDefaultBranchContext(ctx, r.Root)
+	if err != nil {
+		return fmt.Errorf("GuessDefaultBranch: %w", err)
+	}
+	return nil
+}
+
+// acquire acquires a token from semaphore sem, unless ctx is done first.
+func acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
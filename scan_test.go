@@ -0,0 +1,53 @@
+package vcsstate_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/shurcooL/vcsstate"
+	"github.com/shurcooL/vcsstate/vcsstatetest"
+)
+
+func TestScannerScan(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	revisions := make(map[string]string)
+	for _, name := range []string{
+		"a",
+		"b/c",
+		"a/nested", // Inside repository "a", so it should not be found.
+	} {
+		dir := repos.Init(filepath.FromSlash(name))
+		revisions[dir] = repos.Commit(dir, "initial")
+	}
+	if err := os.MkdirAll(filepath.Join(repos.Root, "d", "e"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	s := vcsstate.Scanner{Offline: true, LocalConcurrency: 2}
+	err := s.Scan(context.Background(), repos.Root, func(r vcsstate.RepoState) {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Root, r.Err)
+			return
+		}
+		if r.Branch != r.DefaultBranch || r.LocalRevision != revisions[r.Root] {
+			t.Errorf("%s: unexpected state %+v", r.Root, r)
+		}
+		rel, err := filepath.Rel(repos.Root, r.Root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, filepath.ToSlash(rel))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if want := []string{"a", "b/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got repositories %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package vcsstate
+
+import (
+	"context"
+	"errors"
+)
+
+// Snapshot is the local state of a repository, as returned by VCS.Snapshot.
+type Snapshot struct {
+	Branch BranchInfo // Checked out branch.
+
+	// Upstream is the upstream branch of the checked out branch, e.g., "origin/main",
+	// and Ahead and Behind are how many commits the checked out branch is ahead of
+	// and behind it. They're empty if there's no upstream or HEAD is detached,
+	// and always for hg, which has no upstream branches.
+	Upstream      string
+	Ahead, Behind int
+
+	// Files is the status of each changed or untracked file, like WorkingTreeStatus
+	// returns, except ignored files are not included. It has no entries if no
+	// outstanding status.
+	Files []FileStatus
+
+	Stash bool // Whether the repository has a stash.
+
+	// DefaultBranch is the cached remote default branch if there is one,
+	// as returned by CachedRemoteDefaultBranch, or the guessed default branch
+	// otherwise, as returned by GuessDefaultBranch. LocalRevision is the latest
+	// local revision of DefaultBranch, or empty if it doesn't exist locally.
+	DefaultBranch string
+	LocalRevision string
+}
+
+// composeSnapshot gets a snapshot of the repository rooted at dir
+// by calling other methods of v.
+func composeSnapshot(ctx context.Context, v vcsContext, dir string) (Snapshot, error) {
+	var s Snapshot
+	var err error
+	s.Branch, err = v.BranchInfoContext(ctx, dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	files, err := v.WorkingTreeStatusContext(ctx, dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for _, f := range files {
+		if !f.Ignored {
+			s.Files = append(s.Files, f)
+		}
+	}
+	stash, err := v.StashContext(ctx, dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	s.Stash = stash != ""
+	s.DefaultBranch, err = v.CachedRemoteDefaultBranchContext(ctx, dir)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Snapshot{}, ctx.Err()
+		}
+		s.DefaultBranch, _, err = v.GuessDefaultBranchContext(ctx, dir)
+		if err != nil {
+			return Snapshot{}, err
+		}
+	}
+	s.LocalRevision, err = v.LocalRevisionContext(ctx, dir, s.DefaultBranch)
+	switch {
+	case errors.Is(err, ErrUnknownRevision):
+		s.LocalRevision = "" // Default branch doesn't exist locally.
+	case err != nil:
+		return Snapshot{}, err
+	}
+	return s, nil
+}
@@ -0,0 +1,153 @@
+package vcsstate_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/shurcooL/vcsstate"
+	"github.com/shurcooL/vcsstate/vcsstatetest"
+)
+
+func TestGit28Snapshot(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	remote := repos.Init("remote")
+	repos.Run(remote, "symbolic-ref", "HEAD", "refs/heads/trunk")
+	repos.Run(remote, "commit", "--quiet", "--allow-empty", "-m", "first")
+	repos.Run(remote, "commit", "--quiet", "--allow-empty", "-m", "second")
+	dir := repos.Clone(remote, "clone")
+	repos.Run(remote, "commit", "--quiet", "--allow-empty", "-m", "third")
+	repos.Run(dir, "fetch", "--quiet")
+
+	for _, tc := range []struct {
+		name          string
+		setup         func()
+		remote        string
+		upstream      string
+		ahead, behind int
+	}{
+		{name: "clone", upstream: "origin/trunk", behind: 1},
+		{name: "upstream remote", remote: vcsstate.UpstreamRemote, upstream: "origin/trunk", behind: 1},
+		{name: "ahead and dirty", setup: func() {
+			repos.Run(dir, "commit", "--quiet", "--allow-empty", "-m", "local")
+			repos.WriteFile(dir, "a", "a")
+			repos.WriteFile(dir, "b", "b")
+			repos.WriteFile(dir, ".gitignore", "b\n")
+			repos.Run(dir, "add", "a")
+		}, upstream: "origin/trunk", ahead: 1, behind: 1},
+		{name: "stash", setup: func() { repos.Run(dir, "stash", "--quiet") }, upstream: "origin/trunk", ahead: 1, behind: 1},
+		{name: "no remote HEAD", setup: func() { repos.Run(dir, "remote", "set-head", "origin", "--delete") }, upstream: "origin/trunk", ahead: 1, behind: 1},
+		{name: "detached", setup: func() { repos.Run(dir, "checkout", "--quiet", "HEAD~1") }},
+		{name: "unborn", setup: func() { repos.Run(dir, "checkout", "--quiet", "--orphan", "orphan") }},
+		{name: "unborn upstream remote", remote: vcsstate.UpstreamRemote},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.setup != nil {
+				tc.setup()
+			}
+			v := newGit(t, &vcsstate.Options{Remote: tc.remote})
+			want, err := vcsstate.ComposeSnapshot(context.Background(), v, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want.Upstream, want.Ahead, want.Behind = tc.upstream, tc.ahead, tc.behind
+			got, err := v.Snapshot(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// TestComposeSnapshotLocalRevision checks that composing a snapshot treats a default branch
+// that doesn't exist locally as ordinary state, but returns other LocalRevision errors.
+func TestComposeSnapshotLocalRevision(t *testing.T) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	dir := repos.Init("repo")
+	repos.Run(dir, "symbolic-ref", "HEAD", "refs/heads/trunk")
+	repos.Commit(dir, "first")
+	repos.Run(dir, "checkout", "--quiet", "-b", "feature")
+	repos.Run(dir, "branch", "--quiet", "-D", "trunk")
+	repos.Run(dir, "config", "init.defaultBranch", "trunk")
+
+	s, err := vcsstate.ComposeSnapshot(context.Background(), newGit(t, nil), dir)
+	if err != nil || s.DefaultBranch != "trunk" || s.LocalRevision != "" {
+		t.Errorf("missing default branch: got default branch %q and local revision %q, %v, want %q, empty, nil", s.DefaultBranch, s.LocalRevision, err, "trunk")
+	}
+
+	v := newGit(t, &vcsstate.Options{Runner: failRevParseRunner{revision: s.DefaultBranch}})
+	if _, err := vcsstate.ComposeSnapshot(context.Background(), v, dir); err == nil {
+		t.Error("failing LocalRevision: got nil error, want non-nil")
+	}
+}
+
+// failRevParseRunner runs commands locally, but git rev-parse of revision fails
+// as if the repository was broken.
+type failRevParseRunner struct{ revision string }
+
+func (r failRevParseRunner) Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+	if len(args) > 0 && args[0] == "rev-parse" && args[len(args)-1] == r.revision {
+		return nil, []byte("fatal: not a git repository\n"), errors.New("exit status 128")
+	}
+	return vcsstate.ExecRunner{}.Run(ctx, program, args, dir, env)
+}
+
+// BenchmarkSnapshot compares Snapshot with calling the individual methods
+// it replaces. It reports the number of git processes started per operation.
+func BenchmarkSnapshot(b *testing.B) {
+	if runtime.GOOS == "windows" {
+		b.Skip("counting git processes requires a shell script")
+	}
+	repos := vcsstatetest.NewTempGitRepos(b)
+	remote := repos.Init("remote")
+	repos.Commit(remote, "first")
+	dir := repos.Clone(remote, "clone")
+	repos.Commit(dir, "second")
+	repos.WriteFile(dir, "a", "a")
+
+	// Count git processes with a wrapper script that logs each invocation.
+	log := filepath.Join(repos.Root, "log")
+	git := filepath.Join(repos.Root, "git")
+	script := "#!/bin/sh\necho >> '" + log + "'\nexec git \"$@\"\n"
+	if err := os.WriteFile(git, []byte(script), 0755); err != nil {
+		b.Fatal(err)
+	}
+	v := newGit(b, &vcsstate.Options{GitPath: git})
+
+	for _, bc := range []struct {
+		name string
+		f    func() error
+	}{
+		{"Snapshot", func() error {
+			_, err := v.SnapshotContext(context.Background(), dir)
+			return err
+		}},
+		{"Separate", func() error {
+			_, err := vcsstate.ComposeSnapshot(context.Background(), v, dir)
+			return err
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			os.Remove(log)
+			for i := 0; i < b.N; i++ {
+				if err := bc.f(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			out, err := os.ReadFile(log)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(bytes.Count(out, []byte("\n")))/float64(b.N), "execs/op")
+		})
+	}
+}
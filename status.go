@@ -0,0 +1,299 @@
+package vcsstate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// FileStatus is the status of a single file in the working tree.
+//
+// Mercurial doesn't have a staging area. For hg, changes scheduled via
+// add, remove, copy and rename are reported as Staged, and all other changes
+// (modified or missing files) are reported as Unstaged.
+type FileStatus struct {
+	Path     string // Path relative to repository root.
+	OrigPath string // Path the file was renamed or copied from, if any.
+
+	Staged   Change // Change staged in the index, relative to HEAD.
+	Unstaged Change // Change in the working tree, relative to the index.
+
+	Untracked  bool // File is not tracked.
+	Ignored    bool // File is ignored.
+	Conflicted bool // File has unresolved merge conflicts.
+}
+
+// Change is a kind of change made to a file.
+type Change uint8
+
+const (
+	Unmodified  Change = iota // No change.
+	Modified                  // File contents were modified.
+	Added                     // File was added.
+	Deleted                   // File was deleted.
+	Renamed                   // File was renamed from OrigPath.
+	Copied                    // File was copied from OrigPath.
+	TypeChanged               // File type was changed, e.g., from a regular file to a symlink.
+	Unmerged                  // File is unmerged; see Conflicted.
+)
+
+func (c Change) String() string {
+	switch c {
+	case Unmodified:
+		return "unmodified"
+	case Modified:
+		return "modified"
+	case Added:
+		return "added"
+	case Deleted:
+		return "deleted"
+	case Renamed:
+		return "renamed"
+	case Copied:
+		return "copied"
+	case TypeChanged:
+		return "type changed"
+	case Unmerged:
+		return "unmerged"
+	default:
+		return fmt.Sprintf("Change(%d)", uint8(c))
+	}
+}
+
+// parseGitStatus parses the output of git status --porcelain -z.
+func parseGitStatus(out []byte) ([]FileStatus, error) {
+	var files []FileStatus
+	entries := bytes.Split(out, []byte{0})
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) == 0 {
+			// Output ends with a NUL, which produces a final empty entry.
+			continue
+		}
+		// E.g., "XY path", where X is the index status and Y is the working tree status.
+		if len(entry) < 4 || entry[2] != ' ' {
+			return nil, fmt.Errorf("unexpected status entry %q", entry)
+		}
+		x, y, path := entry[0], entry[1], string(entry[3:])
+		f := FileStatus{Path: path}
+		switch {
+		case x == '?' && y == '?':
+			f.Untracked = true
+		case x == '!' && y == '!':
+			f.Ignored = true
+		default:
+			// Unmerged states are DD, AU, UD, UA, DU, AA, UU.
+			f.Conflicted = x == 'U' || y == 'U' || x == 'D' && y == 'D' || x == 'A' && y == 'A'
+			var err error
+			f.Staged, err = gitChange(x)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected status entry %q: %v", entry, err)
+			}
+			f.Unstaged, err = gitChange(y)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected status entry %q: %v", entry, err)
+			}
+		}
+		if x == 'R' || x == 'C' || y == 'R' || y == 'C' {
+			// With -z, the path a file was renamed or copied from is in the next entry.
+			// Renames in the working tree are detected for intent-to-add files, e.g., " R b\x00a".
+			i++
+			if i >= len(entries) || len(entries[i]) == 0 {
+				return nil, fmt.Errorf("missing original path for status entry %q", entry)
+			}
+			f.OrigPath = string(entries[i])
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// gitStatusV2 is the parsed output of git status --porcelain=v2 --branch -z.
+type gitStatusV2 struct {
+	oid      string // Revision of HEAD, or "(initial)" if the branch is unborn.
+	head     string // Checked out branch, or "(detached)".
+	upstream string
+	ahead    int
+	behind   int
+	files    []FileStatus
+}
+
+// parseGitStatusV2 parses the output of git status --porcelain=v2 --branch -z.
+func parseGitStatusV2(out []byte) (gitStatusV2, error) {
+	var s gitStatusV2
+	entries := bytes.Split(out, []byte{0})
+	for i := 0; i < len(entries); i++ {
+		entry := string(entries[i])
+		if entry == "" {
+			// Output ends with a NUL, which produces a final empty entry.
+			continue
+		}
+		if len(entry) < 2 || entry[1] != ' ' {
+			return gitStatusV2{}, fmt.Errorf("unexpected status entry %q", entry)
+		}
+		var (
+			f      FileStatus
+			xy     string
+			fields []string
+		)
+		switch entry[0] {
+		case '#':
+			// E.g., "# branch.ab +1 -2".
+			key, value, _ := strings.Cut(entry[2:], " ")
+			switch key {
+			case "branch.oid":
+				s.oid = value
+			case "branch.head":
+				s.head = value
+			case "branch.upstream":
+				s.upstream = value
+			case "branch.ab":
+				if _, err := fmt.Sscanf(value, "+%d -%d", &s.ahead, &s.behind); err != nil {
+					return gitStatusV2{}, fmt.Errorf("unexpected status entry %q: %v", entry, err)
+				}
+			}
+			continue
+		case '?':
+			s.files = append(s.files, FileStatus{Path: entry[2:], Untracked: true})
+			continue
+		case '!':
+			s.files = append(s.files, FileStatus{Path: entry[2:], Ignored: true})
+			continue
+		case '1':
+			// E.g., "1 .M N... 100644 100644 100644 <hH> <hI> path".
+			fields = strings.SplitN(entry, " ", 9)
+		case '2':
+			// E.g., "2 R. N... 100644 100644 100644 <hH> <hI> R100 path",
+			// followed by the path the file was renamed or copied from in the next entry.
+			fields = strings.SplitN(entry, " ", 10)
+			i++
+			if i >= len(entries) || len(entries[i]) == 0 {
+				return gitStatusV2{}, fmt.Errorf("missing original path for status entry %q", entry)
+			}
+			f.OrigPath = string(entries[i])
+		case 'u':
+			// E.g., "u UU N... 100644 100644 100644 100644 <h1> <h2> <h3> path".
+			fields = strings.SplitN(entry, " ", 11)
+			f.Conflicted = true
+		default:
+			return gitStatusV2{}, fmt.Errorf("unexpected status entry %q", entry)
+		}
+		if want := map[byte]int{'1': 9, '2': 10, 'u': 11}[entry[0]]; len(fields) != want || len(fields[1]) != 2 {
+			return gitStatusV2{}, fmt.Errorf("unexpected status entry %q", entry)
+		}
+		xy, f.Path = strings.ReplaceAll(fields[1], ".", " "), fields[len(fields)-1]
+		var err error
+		f.Staged, err = gitChange(xy[0])
+		if err != nil {
+			return gitStatusV2{}, fmt.Errorf("unexpected status entry %q: %v", entry, err)
+		}
+		f.Unstaged, err = gitChange(xy[1])
+		if err != nil {
+			return gitStatusV2{}, fmt.Errorf("unexpected status entry %q: %v", entry, err)
+		}
+		s.files = append(s.files, f)
+	}
+	return s, nil
+}
+
+// gitChange returns the Change corresponding to a git status letter.
+func gitChange(c byte) (Change, error) {
+	switch c {
+	case ' ':
+		return Unmodified, nil
+	case 'M':
+		return Modified, nil
+	case 'A':
+		return Added, nil
+	case 'D':
+		return Deleted, nil
+	case 'R':
+		return Renamed, nil
+	case 'C':
+		return Copied, nil
+	case 'T':
+		return TypeChanged, nil
+	case 'U':
+		return Unmerged, nil
+	default:
+		return 0, fmt.Errorf("unknown status letter %q", c)
+	}
+}
+
+// parseHgStatus parses the output of hg status --copies --ignored,
+// and the output of hg resolve --list.
+func parseHgStatus(status, resolve []byte) ([]FileStatus, error) {
+	var (
+		files   []FileStatus
+		removed = make(map[string]int) // Path -> index in files.
+	)
+	for _, line := range strings.Split(strings.TrimSuffix(string(status), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "  ") {
+			// Source of the preceding copied file, e.g., "  old/path".
+			if len(files) == 0 || files[len(files)-1].Staged != Added {
+				return nil, fmt.Errorf("unexpected copy source line %q", line)
+			}
+			files[len(files)-1].OrigPath = line[len("  "):]
+			files[len(files)-1].Staged = Copied
+			continue
+		}
+		// E.g., "M path".
+		if len(line) < 3 || line[1] != ' ' {
+			return nil, fmt.Errorf("unexpected status line %q", line)
+		}
+		f := FileStatus{Path: line[2:]}
+		switch line[0] {
+		case 'M':
+			f.Unstaged = Modified
+		case 'A':
+			f.Staged = Added
+		case 'R':
+			f.Staged = Deleted
+			removed[f.Path] = len(files)
+		case '!':
+			f.Unstaged = Deleted
+		case '?':
+			f.Untracked = true
+		case 'I':
+			f.Ignored = true
+		case 'C':
+			continue // Clean files are not reported.
+		default:
+			return nil, fmt.Errorf("unexpected status line %q", line)
+		}
+		files = append(files, f)
+	}
+
+	// A copy whose source was removed is a rename.
+	drop := make(map[int]bool)
+	for i, f := range files {
+		if f.Staged != Copied {
+			continue
+		}
+		if j, ok := removed[f.OrigPath]; ok {
+			files[i].Staged = Renamed
+			drop[j] = true
+		}
+	}
+
+	// Mark files with unresolved merge conflicts, e.g., "U path".
+	conflicted := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(string(resolve), "\n"), "\n") {
+		if strings.HasPrefix(line, "U ") {
+			conflicted[line[len("U "):]] = true
+		}
+	}
+
+	var result []FileStatus
+	for i, f := range files {
+		if drop[i] {
+			continue
+		}
+		f.Conflicted = conflicted[f.Path]
+		result = append(result, f)
+	}
+	return result, nil
+}
@@ -0,0 +1,152 @@
+package vcsstate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGitStatus(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want []FileStatus
+	}{
+		{
+			in:   []byte(""),
+			want: nil,
+		},
+		{
+			in: []byte("R  a2\x00a\x00MM b\x00 D c\x00?? u\x00!! ign\x00"),
+			want: []FileStatus{
+				{Path: "a2", OrigPath: "a", Staged: Renamed},
+				{Path: "b", Staged: Modified, Unstaged: Modified},
+				{Path: "c", Unstaged: Deleted},
+				{Path: "u", Untracked: true},
+				{Path: "ign", Ignored: true},
+			},
+		},
+		{
+			in: []byte("UU conflict.go\x00AA both added.go\x00"),
+			want: []FileStatus{
+				{Path: "conflict.go", Staged: Unmerged, Unstaged: Unmerged, Conflicted: true},
+				{Path: "both added.go", Staged: Added, Unstaged: Added, Conflicted: true},
+			},
+		},
+		{
+			// Renamed in the working tree, after git add -N.
+			in: []byte(" R b\x00a\x00?? u\x00"),
+			want: []FileStatus{
+				{Path: "b", OrigPath: "a", Unstaged: Renamed},
+				{Path: "u", Untracked: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parseGitStatus(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got %+v, want %+v", got, test.want)
+		}
+	}
+}
+
+func TestParseGitStatusV2(t *testing.T) {
+	const (
+		oid = "7cafcd837844e784b526369c9bce262804aebc60"
+		h   = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+	)
+	tests := []struct {
+		in   []byte
+		want gitStatusV2
+	}{
+		{
+			in:   []byte("# branch.oid (initial)\x00# branch.head main\x00"),
+			want: gitStatusV2{oid: "(initial)", head: "main"},
+		},
+		{
+			in: []byte("# branch.oid " + oid + "\x00# branch.head (detached)\x00" +
+				"1 .M N... 100644 100644 100644 " + h + " " + h + " dir/with space.go\x00"),
+			want: gitStatusV2{oid: oid, head: "(detached)", files: []FileStatus{
+				{Path: "dir/with space.go", Unstaged: Modified},
+			}},
+		},
+		{
+			in: []byte("# branch.oid " + oid + "\x00# branch.head main\x00# branch.upstream origin/main\x00# branch.ab +2 -3\x00" +
+				"2 R. N... 100644 100644 100644 " + h + " " + h + " R100 a2\x00a\x00" +
+				"1 MM N... 100644 100644 100644 " + h + " " + h + " b\x00" +
+				"u UU N... 100644 100644 100644 100644 " + h + " " + h + " " + h + " conflict.go\x00" +
+				"? u\x00! ign\x00"),
+			want: gitStatusV2{oid: oid, head: "main", upstream: "origin/main", ahead: 2, behind: 3, files: []FileStatus{
+				{Path: "a2", OrigPath: "a", Staged: Renamed},
+				{Path: "b", Staged: Modified, Unstaged: Modified},
+				{Path: "conflict.go", Staged: Unmerged, Unstaged: Unmerged, Conflicted: true},
+				{Path: "u", Untracked: true},
+				{Path: "ign", Ignored: true},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parseGitStatusV2(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got %+v, want %+v", got, test.want)
+		}
+	}
+
+	for _, in := range []string{"1 .M\x00", "2 R. N... 100644 100644 100644 " + h + " " + h + " R100 a2\x00", "X y\x00"} {
+		if _, err := parseGitStatusV2([]byte(in)); err == nil {
+			t.Errorf("parseGitStatusV2(%q): got nil error, want non-nil", in)
+		}
+	}
+}
+
+func TestParseHgStatus(t *testing.T) {
+	tests := []struct {
+		status  []byte
+		resolve []byte
+		want    []FileStatus
+	}{
+		{
+			status: []byte(""),
+			want:   nil,
+		},
+		{
+			status: []byte(`M b
+A a2
+  a
+A c2
+  c
+R a
+! d
+? u
+I ign
+`),
+			resolve: []byte(`U b
+R e
+`),
+			want: []FileStatus{
+				{Path: "b", Unstaged: Modified, Conflicted: true},
+				{Path: "a2", OrigPath: "a", Staged: Renamed},
+				{Path: "c2", OrigPath: "c", Staged: Copied},
+				{Path: "d", Unstaged: Deleted},
+				{Path: "u", Untracked: true},
+				{Path: "ign", Ignored: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parseHgStatus(test.status, test.resolve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got %+v, want %+v", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package vcsstate_test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shurcooL/vcsstate"
+	"github.com/shurcooL/vcsstate/vcsstatetest"
+)
+
+var record = flag.Bool("record", false, "record transcripts with the local git binary into testdata/transcripts")
+
+// TestTranscripts tests the git backends end to end by replaying transcripts
+// of the commands they ran, stored in testdata/transcripts/<git version>.
+// So far, only git 2.39.5 is recorded, which exercises the git 2.8+ backend.
+// The git 1.7 backend is exercised by testdata/transcripts/<git version>-git17,
+// recorded with the same git binary but forced by reporting an old git version,
+// so it doesn't cover the output of an actual git 1.7 binary. The results of
+// each recorded call are compared with the results of replaying it. Run with
+// -record to add both transcripts for the local git binary.
+// The hg backend is not covered here, but by TestIntegration when hg is installed.
+func TestTranscripts(t *testing.T) {
+	if *record {
+		out, err := exec.Command("git", "--version").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		version := strings.TrimPrefix(strings.TrimSpace(string(out)), "git version ")
+		recordTranscript(t, version, nil)
+		recordTranscript(t, version+"-git17", oldGitRunner{})
+	}
+	dirs, err := filepath.Glob(filepath.Join("testdata", "transcripts", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("no transcripts in testdata/transcripts")
+	}
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			transcript, err := vcsstatetest.ReadTranscript(filepath.Join(dir, "transcript.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(filepath.Join(dir, "results.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []transcriptResult
+			if err := json.Unmarshal(b, &want); err != nil {
+				t.Fatal(err)
+			}
+			r := &vcsstatetest.Replayer{Transcript: transcript}
+			got := runTranscriptCalls(r, "$ROOT")
+			if !reflect.DeepEqual(got, want) {
+				for i := 0; i < len(got) && i < len(want); i++ {
+					if got[i] != want[i] {
+						t.Errorf("%s: got %s, want %s", want[i].Call, got[i].Result, want[i].Result)
+					}
+				}
+				if len(got) != len(want) {
+					t.Errorf("got %d results, want %d", len(got), len(want))
+				}
+			}
+			if n := r.Remaining(); n != 0 {
+				t.Errorf("%d commands in transcript were not replayed", n)
+			}
+		})
+	}
+}
+
+// transcriptResult is the result of a call made by runTranscriptCalls.
+type transcriptResult struct {
+	Call   string `json:"call"`
+	Result string `json:"result"`
+}
+
+// runTranscriptCalls calls the methods of VCS and RemoteVCS that are covered
+// by transcripts, using runner, for the repositories in root.
+func runTranscriptCalls(runner vcsstate.Runner, root string) []transcriptResult {
+	var results []transcriptResult
+	add := func(call string, values ...interface{}) {
+		results = append(results, transcriptResult{Call: call, Result: strings.ReplaceAll(fmt.Sprintf("%+v", values), root, "$ROOT")})
+	}
+	opt := &vcsstate.Options{Runner: runner}
+	v, err := vcsstate.New(vcsstate.Git, opt)
+	if err != nil {
+		add("NewVCS", err)
+		return results
+	}
+	clone := root + "/clone"
+	add("Status", errString(v.Status(clone)))
+	add("WorkingTreeStatus", errString(v.WorkingTreeStatus(clone)))
+	add("Branch", errString(v.Branch(clone)))
+	add("BranchInfo", errString(v.BranchInfo(clone)))
+	add("LocalRevision", errString(v.LocalRevision(clone, "main")))
+	add("Stash", errString(v.Stash(clone)))
+	add("Contains", errString(v.Contains(clone, "main~1", "main")))
+	add("RemoteContains", errString(v.RemoteContains(clone, "main", "main")))
+	ahead, behind, mergeBase, err := v.AheadBehind(clone, "main")
+	add("AheadBehind", errString([]interface{}{ahead, behind, mergeBase}, err))
+	add("RemoteURL", errString(v.RemoteURL(clone)))
+	add("RemoteHead", errString(v.RemoteHead(clone)))
+	add("CachedRemoteDefaultBranch", errString(v.CachedRemoteDefaultBranch(clone)))
+	branch, source, err := v.GuessDefaultBranch(clone)
+	add("GuessDefaultBranch", errString([]interface{}{branch, source}, err))
+	add("Snapshot", errString(v.Snapshot(clone)))
+	add("RemoteURL without remote", errString(v.RemoteURL(root+"/remote")))
+
+	rv, err := vcsstate.NewRemote(vcsstate.Git, opt)
+	if err != nil {
+		add("NewRemoteVCS", err)
+		return results
+	}
+	add("RemoteVCS.RemoteHead", errString(rv.RemoteHead(root+"/remote")))
+	add("RemoteVCS.RemoteHead not found", errString(rv.RemoteHead(root+"/missing")))
+	return results
+}
+
+// errString returns v and err, with err formatted for comparison,
+// since errors are not preserved in results.json.
+func errString(v interface{}, err error) interface{} {
+	if err != nil {
+		return fmt.Sprintf("%T: %v", err, err)
+	}
+	return v
+}
+
+// recordTranscript records a transcript with the local git binary
+// into testdata/transcripts/<name>, running commands with runner.
+func recordTranscript(t *testing.T, name string, runner vcsstate.Runner) {
+	repos := vcsstatetest.NewTempGitRepos(t)
+	root := repos.Root
+	// Fix dates so that revisions are the same across recordings.
+	t.Setenv("GIT_AUTHOR_DATE", "2020-01-01T00:00:00Z")
+	t.Setenv("GIT_COMMITTER_DATE", "2020-01-01T00:00:00Z")
+	remote := repos.Init("remote")
+	repos.Run(remote, "commit", "--quiet", "--allow-empty", "-m", "first")
+	repos.Run(remote, "commit", "--quiet", "--allow-empty", "-m", "second")
+	clone := repos.Clone("remote", "clone")
+	repos.Run(remote, "commit", "--quiet", "--allow-empty", "-m", "third")
+	repos.Run(clone, "fetch", "--quiet")
+	repos.Run(clone, "commit", "--quiet", "--allow-empty", "-m", "local")
+	repos.WriteFile(clone, "stashed", "")
+	repos.Run(clone, "stash", "--quiet", "--include-untracked")
+	repos.WriteFile(clone, "untracked", "")
+
+	r := &vcsstatetest.Recorder{Runner: runner, Root: root}
+	results := runTranscriptCalls(r, root)
+	dir := filepath.Join("testdata", "transcripts", name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Transcript().WriteFile(filepath.Join(dir, "transcript.json")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.MarshalIndent(results, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "results.json"), append(b, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
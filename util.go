@@ -2,14 +2,86 @@ package vcsstate
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"os/exec"
+	"strings"
 )
 
-// dividedOutput runs the command and returns its standard output and standard error.
-func dividedOutput(cmd *exec.Cmd) (stdout []byte, stderr []byte, err error) {
-	var outb, errb bytes.Buffer
-	cmd.Stdout = &outb
-	cmd.Stderr = &errb
-	err = cmd.Run()
-	return outb.Bytes(), errb.Bytes(), err
+// dividedOutput runs the command described by cmd using r, and returns its standard output
+// and standard error. Only the Args, Dir and Env of cmd are used. If r is nil, ExecRunner is used.
+// If ctx is done before the command completes, ctx.Err() is returned as the error.
+func dividedOutput(ctx context.Context, r Runner, cmd *exec.Cmd) (stdout []byte, stderr []byte, err error) {
+	if r == nil {
+		r = ExecRunner{}
+	}
+	stdout, stderr, err = r.Run(ctx, cmd.Args[0], cmd.Args[1:], cmd.Dir, cmd.Env)
+	if err != nil && ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	return stdout, stderr, err
+}
+
+// remoteError returns an error for a failed git or hg command that accessed a remote.
+// The failure is classified by looking for known messages in its standard error output,
+// and the returned error is of type NotFoundError, OfflineError, AuthenticationError,
+// HostKeyError or PermissionDeniedError. If the failure is unknown, the error is returned
+// with standard error output appended.
+func remoteError(err error, stderr []byte) error {
+	err = fmt.Errorf("%v: %s", err, strings.TrimSuffix(string(stderr), "\n"))
+	containsAny := func(substrs ...string) bool {
+		for _, s := range substrs {
+			if bytes.Contains(stderr, []byte(s)) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case containsAny(
+		"Host key verification failed.",
+		"and you have requested strict checking.",
+		"REMOTE HOST IDENTIFICATION HAS CHANGED",
+	):
+		return HostKeyError{Err: err}
+	case containsAny(
+		"Could not resolve host",               // git over HTTPS.
+		"Could not resolve hostname",           // ssh.
+		"Failed to connect to",                 // git over HTTPS.
+		"Couldn't connect to server",           // git over HTTPS.
+		"Network is unreachable",               // ssh, hg.
+		"Connection refused",                   // ssh, hg.
+		"Connection timed out",                 // ssh, hg.
+		"Operation timed out",                  // ssh, hg.
+		"Name or service not known",            // hg.
+		"nodename nor servname provided",       // hg.
+		"Temporary failure in name resolution", // hg.
+	):
+		return OfflineError{Err: err}
+	case containsAny(
+		"could not read Username",
+		"could not read Password",
+		"Authentication failed",
+		"The requested URL returned error: 401",
+		"http authorization required",  // hg.
+		"HTTP Error 401",               // hg.
+		"Permission denied (publickey", // ssh, when no key was accepted.
+	):
+		return AuthenticationError{Err: err}
+	case containsAny(
+		"The requested URL returned error: 403",
+		"HTTP Error 403", // hg.
+	) || bytes.Contains(stderr, []byte("Permission to ")) && bytes.Contains(stderr, []byte(" denied")):
+		return PermissionDeniedError{Err: err}
+	case containsAny(
+		"Repository not found.",
+		"does not appear to be a git repository",
+		"The requested URL returned error: 404",
+		"HTTP Error 404", // hg.
+	) || bytes.HasPrefix(stderr, []byte("fatal: repository '")) && bytes.Contains(stderr, []byte("' not found\n")) ||
+		bytes.HasPrefix(stderr, []byte("abort: repository ")) && bytes.Contains(stderr, []byte(" not found")): // hg.
+		return NotFoundError{Err: err}
+	default:
+		return err
+	}
 }
@@ -0,0 +1,90 @@
+package vcsstate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRemoteError(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   error // Only the type is compared.
+	}{
+		{
+			stderr: "fatal: unable to access 'https://github.com/shurcooL/gostatus/': Could not resolve host: github.com\n",
+			want:   OfflineError{},
+		},
+		{
+			stderr: `ssh: connect to host 127.0.0.1 port 1: Connection refused
+fatal: Could not read from remote repository.
+
+Please make sure you have the correct access rights
+and the repository exists.
+`,
+			want: OfflineError{},
+		},
+		{
+			stderr: "fatal: could not read Username for 'https://github.com': terminal prompts disabled\n",
+			want:   AuthenticationError{},
+		},
+		{
+			stderr: "fatal: Authentication failed for 'https://example.com/private.git/'\n",
+			want:   AuthenticationError{},
+		},
+		{
+			stderr: `No ED25519 host key is known for github.com and you have requested strict checking.
+Host key verification failed.
+fatal: Could not read from remote repository.
+`,
+			want: HostKeyError{},
+		},
+		{
+			stderr: `git@github.com: Permission denied (publickey).
+fatal: Could not read from remote repository.
+`,
+			want: AuthenticationError{},
+		},
+		{
+			stderr: `ERROR: Permission to someone/private.git denied to deploy key
+fatal: Could not read from remote repository.
+`,
+			want: PermissionDeniedError{},
+		},
+		{
+			stderr: "remote: Permission to someone/private.git denied to me.\nfatal: unable to access 'https://github.com/someone/private.git/': The requested URL returned error: 403\n",
+			want:   PermissionDeniedError{},
+		},
+		{
+			stderr: "remote: Repository not found.\nfatal: repository 'https://github.com/shurcooL/nonexistent/' not found\n",
+			want:   NotFoundError{},
+		},
+		{
+			stderr: "abort: repository /nonexistent/repo not found!\n",
+			want:   NotFoundError{},
+		},
+		{
+			stderr: "fatal: '/nonexistent/repo' does not appear to be a git repository\n",
+			want:   NotFoundError{},
+		},
+		{
+			stderr: "abort: error: Temporary failure in name resolution\n",
+			want:   OfflineError{},
+		},
+		{
+			stderr: "abort: http authorization required for https://example.com/hg/private\n",
+			want:   AuthenticationError{},
+		},
+		{
+			stderr: "fatal: something unexpected\n",
+			want:   errors.New(""),
+		},
+	}
+
+	for _, test := range tests {
+		err := remoteError(errors.New("exit status 128"), []byte(test.stderr))
+		if got, want := reflect.TypeOf(err), reflect.TypeOf(test.want); got != want {
+			t.Errorf("%q: got %v, want %v", test.stderr, got, want)
+		}
+	}
+}
@@ -2,9 +2,10 @@
 package vcsstate
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 
 	"golang.org/x/tools/go/vcs"
 )
@@ -12,6 +13,15 @@ import (
 // ErrNoRemote is the error used when the local repository doesn't have a valid remote.
 var ErrNoRemote = errors.New("local repository has no valid remote")
 
+// ErrUnknownRevision is the error used when a branch or revision doesn't exist
+// in the local repository, e.g., because the checked out branch is unborn.
+// It's wrapped by errors that name the revision.
+var ErrUnknownRevision = errors.New("unknown revision")
+
+// ErrUnsupported is the error used when an operation is not supported
+// by the VCS implementation, e.g., one selected by Options.NativeGit.
+var ErrUnsupported = errors.New("operation not supported by this implementation")
+
 // NotFoundError records an error where the remote repository is not found.
 type NotFoundError struct {
 	Err error // Underlying error with more details.
@@ -21,17 +31,84 @@ func (e NotFoundError) Error() string {
 	return fmt.Sprintf("remote repository not found:\n%v", e.Err)
 }
 
+func (e NotFoundError) Unwrap() error { return e.Err }
+
+// OfflineError records an error where the remote host can't be reached,
+// e.g., because the network is offline or the host name can't be resolved.
+// When offline, CachedRemoteDefaultBranch can be used as a fallback.
+type OfflineError struct {
+	Err error // Underlying error with more details.
+}
+
+func (e OfflineError) Error() string {
+	return fmt.Sprintf("remote host unreachable:\n%v", e.Err)
+}
+
+func (e OfflineError) Unwrap() error { return e.Err }
+
+// AuthenticationError records an error where the remote repository requires
+// authentication, and credentials weren't available or were rejected.
+type AuthenticationError struct {
+	Err error // Underlying error with more details.
+}
+
+func (e AuthenticationError) Error() string {
+	return fmt.Sprintf("remote authentication required:\n%v", e.Err)
+}
+
+func (e AuthenticationError) Unwrap() error { return e.Err }
+
+// HostKeyError records an error where the SSH host key of the remote host
+// couldn't be verified, e.g., because it's not in known_hosts or it has changed.
+type HostKeyError struct {
+	Err error // Underlying error with more details.
+}
+
+func (e HostKeyError) Error() string {
+	return fmt.Sprintf("remote host key verification failed:\n%v", e.Err)
+}
+
+func (e HostKeyError) Unwrap() error { return e.Err }
+
+// PermissionDeniedError records an error where access to the remote repository
+// is denied to the authenticated user (or SSH key).
+type PermissionDeniedError struct {
+	Err error // Underlying error with more details.
+}
+
+func (e PermissionDeniedError) Error() string {
+	return fmt.Sprintf("remote permission denied:\n%v", e.Err)
+}
+
+func (e PermissionDeniedError) Unwrap() error { return e.Err }
+
 // VCS describes how to use a version control system to get the status of a repository
 // rooted at dir.
+//
+// Methods with the Context suffix are like their counterparts without it,
+// but they use ctx to cancel the underlying commands. If ctx is done before
+// a command completes, the command is killed and ctx.Err() is returned,
+// so callers can detect an exceeded deadline via context.DeadlineExceeded.
 type VCS interface {
 	// Status returns the status of working directory.
 	// It returns empty string if no outstanding status.
 	Status(dir string) (string, error)
 
+	// WorkingTreeStatus returns the status of each changed, untracked or ignored
+	// file in the working directory. It returns no entries if no outstanding status.
+	WorkingTreeStatus(dir string) ([]FileStatus, error)
+
 	// Branch returns the name of the locally checked out branch.
+	// Use BranchInfo to tell apart a detached HEAD or an unborn branch.
 	Branch(dir string) (string, error)
 
+	// BranchInfo returns information about the locally checked out branch,
+	// including whether HEAD is detached or the branch is unborn.
+	BranchInfo(dir string) (BranchInfo, error)
+
 	// LocalRevision returns current local revision of default branch.
+	// If the default branch doesn't exist locally, an error wrapping
+	// ErrUnknownRevision is returned.
 	LocalRevision(dir string, defaultBranch string) (string, error)
 
 	// Stash returns a non-empty string if the repository has a stash.
@@ -42,9 +119,22 @@ type VCS interface {
 	Contains(dir string, revision string, defaultBranch string) (bool, error)
 
 	// RemoteContains reports whether the remote default branch contains
-	// the commit specified by revision.
+	// the commit specified by revision. For git, it uses the remote-tracking
+	// branch as of the last fetch. For hg, see HgRemoteContainsMode.
+	// If there's no remote, then ErrNoRemote is returned.
 	RemoteContains(dir string, revision string, defaultBranch string) (bool, error)
 
+	// AheadBehind reports how many commits the local default branch is ahead of
+	// and behind the remote default branch, and the revision of their merge base.
+	// The merge base is empty if the branches have no common ancestor.
+	// For git, the remote default branch is the remote-tracking branch as of
+	// the last fetch, so this operation doesn't use network. For hg, the remote
+	// is queried directly, so this operation requires network.
+	// If there's no remote, then ErrNoRemote is returned. For git, if the default
+	// branch or its remote-tracking branch doesn't exist, an error wrapping
+	// ErrUnknownRevision is returned.
+	AheadBehind(dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error)
+
 	// RemoteURL returns primary remote URL, as set in the local repository.
 	// If there's no remote, then ErrNoRemote is returned.
 	RemoteURL(dir string) (string, error)
@@ -55,77 +145,405 @@ type VCS interface {
 	// If the remote repository is not found, NotFoundError is returned,
 	// and the default branch can be queried with NoRemoteDefaultBranch.
 	// This operation requires the use of network, and will fail if offline.
-	// When offline, CachedRemoteDefaultBranch can be used as a fallback.
+	// When offline, OfflineError is returned, and CachedRemoteDefaultBranch
+	// can be used as a fallback. Other remote failures are reported via
+	// AuthenticationError, HostKeyError and PermissionDeniedError.
 	RemoteBranchAndRevision(dir string) (branch string, revision string, err error)
 
+	// RemoteHead is like RemoteBranchAndRevision, but it also reports
+	// the convention by which the remote default branch was determined.
+	// For hg, the "@" bookmark is preferred over the tip of the "default" branch.
+	RemoteHead(dir string) (RemoteHead, error)
+
 	// CachedRemoteDefaultBranch returns a locally cached remote default branch,
 	// if it can do so successfully. It can be used to make a best effort guess
 	// of the remote default branch when offline. If it fails, the only viable
 	// next best fallback before online again is to use NoRemoteDefaultBranch.
-	CachedRemoteDefaultBranch() (string, error)
+	// For git, it's the remote HEAD cached in refs/remotes/<remote>/HEAD,
+	// which is set by git clone and git remote set-head.
+	CachedRemoteDefaultBranch(dir string) (string, error)
 
 	// NoRemoteDefaultBranch returns the default value of default branch for this vcs.
 	// It can only be relied on when there's no remote, since remote can have a custom
 	// value of default branch.
 	NoRemoteDefaultBranch() string
+
+	// GuessDefaultBranch makes a best effort guess of the default branch
+	// of the repository rooted at dir, without using its remote. Unlike
+	// NoRemoteDefaultBranch, it takes the repository into account. For git,
+	// it prefers init.defaultBranch from repository or user configuration if that
+	// branch exists locally, then the first of "main", "master" and "trunk" that
+	// exists locally, then init.defaultBranch, and then NoRemoteDefaultBranch.
+	// It reports which of those sources the guess was derived from.
+	GuessDefaultBranch(dir string) (branch string, source DefaultBranchSource, err error)
+
+	// Snapshot returns the local state of the repository in one call.
+	// It doesn't use network. For git 2.16+, it runs as few commands
+	// as possible, so it's cheaper than calling the individual methods.
+	Snapshot(dir string) (Snapshot, error)
+
+	// Worktrees returns the working trees of the repository, starting with
+	// the main working tree, followed by linked ones, as created by
+	// git worktree add, sorted by path. For hg, which has a single working
+	// directory per repository, it returns only that one. Before git 2.7,
+	// linked working trees can't be listed, so it returns only the one
+	// containing dir, as the main working tree.
+	Worktrees(dir string) ([]Worktree, error)
+
+	// IsMainWorktree reports whether dir is the main working tree
+	// of its repository, rather than a linked one. It's always true for hg.
+	IsMainWorktree(dir string) (bool, error)
+
+	// StatusContext is like Status, but it uses ctx.
+	StatusContext(ctx context.Context, dir string) (string, error)
+
+	// WorkingTreeStatusContext is like WorkingTreeStatus, but it uses ctx.
+	WorkingTreeStatusContext(ctx context.Context, dir string) ([]FileStatus, error)
+
+	// BranchContext is like Branch, but it uses ctx.
+	BranchContext(ctx context.Context, dir string) (string, error)
+
+	// BranchInfoContext is like BranchInfo, but it uses ctx.
+	BranchInfoContext(ctx context.Context, dir string) (BranchInfo, error)
+
+	// LocalRevisionContext is like LocalRevision, but it uses ctx.
+	LocalRevisionContext(ctx context.Context, dir string, defaultBranch string) (string, error)
+
+	// StashContext is like Stash, but it uses ctx.
+	StashContext(ctx context.Context, dir string) (string, error)
+
+	// ContainsContext is like Contains, but it uses ctx.
+	ContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error)
+
+	// RemoteContainsContext is like RemoteContains, but it uses ctx.
+	RemoteContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error)
+
+	// AheadBehindContext is like AheadBehind, but it uses ctx.
+	AheadBehindContext(ctx context.Context, dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error)
+
+	// RemoteURLContext is like RemoteURL, but it uses ctx.
+	RemoteURLContext(ctx context.Context, dir string) (string, error)
+
+	// RemoteBranchAndRevisionContext is like RemoteBranchAndRevision, but it uses ctx.
+	RemoteBranchAndRevisionContext(ctx context.Context, dir string) (branch string, revision string, err error)
+
+	// RemoteHeadContext is like RemoteHead, but it uses ctx.
+	RemoteHeadContext(ctx context.Context, dir string) (RemoteHead, error)
+
+	// CachedRemoteDefaultBranchContext is like CachedRemoteDefaultBranch, but it uses ctx.
+	CachedRemoteDefaultBranchContext(ctx context.Context, dir string) (string, error)
+
+	// GuessDefaultBranchContext is like GuessDefaultBranch, but it uses ctx.
+	GuessDefaultBranchContext(ctx context.Context, dir string) (branch string, source DefaultBranchSource, err error)
+
+	// SnapshotContext is like Snapshot, but it uses ctx.
+	SnapshotContext(ctx context.Context, dir string) (Snapshot, error)
+
+	// WorktreesContext is like Worktrees, but it uses ctx.
+	WorktreesContext(ctx context.Context, dir string) ([]Worktree, error)
+
+	// IsMainWorktreeContext is like IsMainWorktree, but it uses ctx.
+	IsMainWorktreeContext(ctx context.Context, dir string) (bool, error)
 }
 
-// NewVCS creates a VCS with same type as vcs.
-func NewVCS(vcs *vcs.Cmd) (VCS, error) {
-	switch vcs.Cmd {
-	case "git":
-		if gitBinaryError != nil {
-			return nil, gitBinaryError
+// Options specifies options for NewVCSWithOptions and NewRemoteVCSWithOptions.
+// A nil *Options is equivalent to the zero value, which gives default behavior.
+type Options struct {
+	// GitPath is the git executable to use. If empty, "git" is looked up in PATH.
+	GitPath string
+
+	// HgPath is the hg executable to use. If empty, "hg" is looked up in PATH.
+	HgPath string
+
+	// Remote is the name of the git remote used by remote-facing methods,
+	// such as RemoteURL, RemoteBranchAndRevision and RemoteContains.
+	// If empty, "origin" is used. If UpstreamRemote, the remote of
+	// the checked out branch's configured upstream is used.
+	// It has no effect for hg and for RemoteVCS.
+	Remote string
+
+	// HgRemoteContains selects how RemoteContains works for hg,
+	// either offline using phases (the default) or online using outgoing.
+	// It has no effect for git and for RemoteVCS.
+	HgRemoteContains HgRemoteContainsMode
+
+	// NativeGit selects git implementations that don't run the git binary,
+	// so GitPath is not used. For VCS, the repository is read directly.
+	// It supports Branch, BranchInfo, LocalRevision, Stash, Contains,
+	// RemoteContains, RemoteURL, CachedRemoteDefaultBranch,
+	// GuessDefaultBranch, Worktrees and IsMainWorktree.
+	// Other methods return ErrUnsupported.
+	// Includes in git configuration files are not followed.
+	// For RemoteVCS, the git smart HTTP protocol is used,
+	// so only http and https remote URLs are supported.
+	// It has no effect for hg.
+	NativeGit bool
+
+	// HTTPClient is the HTTP client used by RemoteVCS when NativeGit is set.
+	// If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Runner runs git and hg commands, including the one that probes
+	// the git version. If nil, ExecRunner is used. If not nil, the hg
+	// binary is not looked up in advance. It has no effect when NativeGit
+	// is set.
+	Runner Runner
+}
+
+func (opt *Options) gitPath() string {
+	if opt.GitPath == "" {
+		return "git"
+	}
+	return opt.GitPath
+}
+
+func (opt *Options) hgPath() string {
+	if opt.HgPath == "" {
+		return "hg"
+	}
+	return opt.HgPath
+}
+
+// UpstreamRemote is a special value for Options.Remote. It selects the remote
+// of the checked out branch's configured upstream (i.e., branch.<name>.remote).
+// If HEAD is detached or there's no configured upstream, remote-facing methods
+// return ErrNoRemote.
+const UpstreamRemote = "@{upstream}"
+
+// New creates a VCS of the given kind, configured by opt.
+// If opt is nil, the zero Options are used.
+// The version of the git binary is probed on first use. If Options.Runner
+// is nil or ExecRunner, a successful result is cached for the lifetime of
+// the process; other runners probe it every time. Failures are retried
+// on the next call.
+func New(kind Kind, opt *Options) (VCS, error) {
+	if opt == nil {
+		opt = &Options{}
+	}
+	switch kind {
+	case Git:
+		if opt.NativeGit {
+			return backgroundVCS{gitNative{remote: opt.Remote}}, nil
 		}
-		var major, minor int
-		_, err := fmt.Fscanf(bytes.NewReader(gitBinaryVersion), "git version %d.%d", &major, &minor)
-		if err != nil {
-			return nil, err
+		git := opt.gitPath()
+		v := probeGitVersion(opt.Runner, git)
+		if v.err != nil {
+			return nil, v.err
 		}
-		if major > 2 || major == 2 && minor >= 8 {
-			return git28{}, nil
-		} else if major > 1 || major == 1 && minor >= 7 {
-			return git17{}, nil
+		if v.major > 2 || v.major == 2 && v.minor >= 8 {
+			return backgroundVCS{git28{git: git, remote: opt.Remote, runner: opt.Runner}}, nil
+		} else if v.major > 1 || v.major == 1 && v.minor >= 7 {
+			return backgroundVCS{git17{git: git, remote: opt.Remote, runner: opt.Runner}}, nil
 		} else {
-			return nil, fmt.Errorf("git support requires git binary version 1.7+, but you have: %q", gitBinaryVersion)
+			return nil, fmt.Errorf("git support requires git binary version 1.7+, but you have: %q", v.out)
 		}
-	case "hg":
-		return hg{}, hgBinaryError
+	case Hg:
+		hgPath := opt.hgPath()
+		return backgroundVCS{hg{hg: hgPath, remoteContains: opt.HgRemoteContains, runner: opt.Runner}}, lookHgBinary(opt.Runner, hgPath)
 	default:
-		return nil, fmt.Errorf("%v (%v) support not implemented", vcs.Name, vcs.Cmd)
+		return nil, fmt.Errorf("%v (%v) support not implemented", kindNames[kind], kind)
 	}
 }
 
+// NewVCS creates a VCS with same type as vcs.
+// It's equivalent to New(KindOf(vcs), nil).
+func NewVCS(vcs *vcs.Cmd) (VCS, error) {
+	return New(KindOf(vcs), nil)
+}
+
+// NewVCSWithOptions creates a VCS with same type as vcs, configured by opt.
+// It's equivalent to New(KindOf(vcs), opt).
+func NewVCSWithOptions(vcs *vcs.Cmd, opt *Options) (VCS, error) {
+	return New(KindOf(vcs), opt)
+}
+
 // RemoteVCS describes how to use a version control system to get the remote status of a repository
 // with remoteURL.
 type RemoteVCS interface {
 	// RemoteBranchAndRevision returns the name and latest revision of the default branch
 	// from the remote. If the remote repository is not found, NotFoundError is returned.
+	// Other remote failures are reported via OfflineError, AuthenticationError,
+	// HostKeyError and PermissionDeniedError.
 	RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error)
+
+	// RemoteHead is like RemoteBranchAndRevision, but it also reports
+	// the convention by which the remote default branch was determined.
+	RemoteHead(remoteURL string) (RemoteHead, error)
+
+	// RemoteBranchAndRevisionContext is like RemoteBranchAndRevision, but it uses
+	// ctx to cancel the underlying command. If ctx is done before the command
+	// completes, the command is killed and ctx.Err() is returned.
+	RemoteBranchAndRevisionContext(ctx context.Context, remoteURL string) (branch string, revision string, err error)
+
+	// RemoteHeadContext is like RemoteHead, but it uses ctx.
+	RemoteHeadContext(ctx context.Context, remoteURL string) (RemoteHead, error)
 }
 
-// NewRemoteVCS creates a RemoteVCS with same type as vcs.
-func NewRemoteVCS(vcs *vcs.Cmd) (RemoteVCS, error) {
-	switch vcs.Cmd {
-	case "git":
-		if gitBinaryError != nil {
-			return nil, gitBinaryError
+// NewRemote creates a RemoteVCS of the given kind, configured by opt.
+// If opt is nil, the zero Options are used.
+// The version of the git binary is probed on first use. If Options.Runner
+// is nil or ExecRunner, a successful result is cached for the lifetime of
+// the process; other runners probe it every time. Failures are retried
+// on the next call.
+func NewRemote(kind Kind, opt *Options) (RemoteVCS, error) {
+	if opt == nil {
+		opt = &Options{}
+	}
+	switch kind {
+	case Git:
+		if opt.NativeGit {
+			return backgroundRemoteVCS{remoteGitHTTP{client: opt.HTTPClient}}, nil
 		}
-		var major, minor int
-		_, err := fmt.Fscanf(bytes.NewReader(gitBinaryVersion), "git version %d.%d", &major, &minor)
-		if err != nil {
-			return nil, err
+		git := opt.gitPath()
+		v := probeGitVersion(opt.Runner, git)
+		if v.err != nil {
+			return nil, v.err
 		}
-		if major > 2 || major == 2 && minor >= 8 {
-			return remoteGit28{}, nil
-		} else if major > 1 || major == 1 && minor >= 7 {
-			return remoteGit17{}, nil
+		if v.major > 2 || v.major == 2 && v.minor >= 8 {
+			return backgroundRemoteVCS{remoteGit28{git: git, runner: opt.Runner}}, nil
+		} else if v.major > 1 || v.major == 1 && v.minor >= 7 {
+			return backgroundRemoteVCS{remoteGit17{git: git, runner: opt.Runner}}, nil
 		} else {
-			return nil, fmt.Errorf("remote git support requires git binary version 1.7+, but you have: %q", gitBinaryVersion)
+			return nil, fmt.Errorf("remote git support requires git binary version 1.7+, but you have: %q", v.out)
 		}
-	case "hg":
-		return remoteHg{}, hgBinaryError
+	case Hg:
+		hgPath := opt.hgPath()
+		return backgroundRemoteVCS{remoteHg{hg: hgPath, runner: opt.Runner}}, lookHgBinary(opt.Runner, hgPath)
 	default:
-		return nil, fmt.Errorf("%v (%v) support not implemented", vcs.Name, vcs.Cmd)
+		return nil, fmt.Errorf("%v (%v) support not implemented", kindNames[kind], kind)
 	}
 }
+
+// NewRemoteVCS creates a RemoteVCS with same type as vcs.
+// It's equivalent to NewRemote(KindOf(vcs), nil).
+func NewRemoteVCS(vcs *vcs.Cmd) (RemoteVCS, error) {
+	return NewRemote(KindOf(vcs), nil)
+}
+
+// NewRemoteVCSWithOptions creates a RemoteVCS with same type as vcs, configured by opt.
+// It's equivalent to NewRemote(KindOf(vcs), opt).
+func NewRemoteVCSWithOptions(vcs *vcs.Cmd, opt *Options) (RemoteVCS, error) {
+	return NewRemote(KindOf(vcs), opt)
+}
+
+// vcsContext is the part of VCS that backends implement.
+// The remaining methods are provided by backgroundVCS.
+type vcsContext interface {
+	StatusContext(ctx context.Context, dir string) (string, error)
+	WorkingTreeStatusContext(ctx context.Context, dir string) ([]FileStatus, error)
+	BranchContext(ctx context.Context, dir string) (string, error)
+	BranchInfoContext(ctx context.Context, dir string) (BranchInfo, error)
+	LocalRevisionContext(ctx context.Context, dir string, defaultBranch string) (string, error)
+	StashContext(ctx context.Context, dir string) (string, error)
+	ContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error)
+	RemoteContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error)
+	AheadBehindContext(ctx context.Context, dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error)
+	RemoteURLContext(ctx context.Context, dir string) (string, error)
+	RemoteHeadContext(ctx context.Context, dir string) (RemoteHead, error)
+	CachedRemoteDefaultBranchContext(ctx context.Context, dir string) (string, error)
+	GuessDefaultBranchContext(ctx context.Context, dir string) (branch string, source DefaultBranchSource, err error)
+	SnapshotContext(ctx context.Context, dir string) (Snapshot, error)
+	WorktreesContext(ctx context.Context, dir string) ([]Worktree, error)
+	IsMainWorktreeContext(ctx context.Context, dir string) (bool, error)
+	NoRemoteDefaultBranch() string
+}
+
+// backgroundVCS implements VCS by calling the Context methods
+// of a backend with context.Background().
+type backgroundVCS struct{ vcsContext }
+
+func (v backgroundVCS) Status(dir string) (string, error) {
+	return v.StatusContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) WorkingTreeStatus(dir string) ([]FileStatus, error) {
+	return v.WorkingTreeStatusContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) Branch(dir string) (string, error) {
+	return v.BranchContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) BranchInfo(dir string) (BranchInfo, error) {
+	return v.BranchInfoContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) LocalRevision(dir string, defaultBranch string) (string, error) {
+	return v.LocalRevisionContext(context.Background(), dir, defaultBranch)
+}
+
+func (v backgroundVCS) Stash(dir string) (string, error) {
+	return v.StashContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	return v.ContainsContext(context.Background(), dir, revision, defaultBranch)
+}
+
+func (v backgroundVCS) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+	return v.RemoteContainsContext(context.Background(), dir, revision, defaultBranch)
+}
+
+func (v backgroundVCS) AheadBehind(dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error) {
+	return v.AheadBehindContext(context.Background(), dir, defaultBranch)
+}
+
+func (v backgroundVCS) RemoteURL(dir string) (string, error) {
+	return v.RemoteURLContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	return v.RemoteBranchAndRevisionContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) RemoteBranchAndRevisionContext(ctx context.Context, dir string) (branch string, revision string, err error) {
+	head, err := v.RemoteHeadContext(ctx, dir)
+	return head.Branch, head.Revision, err
+}
+
+func (v backgroundVCS) RemoteHead(dir string) (RemoteHead, error) {
+	return v.RemoteHeadContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) CachedRemoteDefaultBranch(dir string) (string, error) {
+	return v.CachedRemoteDefaultBranchContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) GuessDefaultBranch(dir string) (branch string, source DefaultBranchSource, err error) {
+	return v.GuessDefaultBranchContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) Snapshot(dir string) (Snapshot, error) {
+	return v.SnapshotContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) Worktrees(dir string) ([]Worktree, error) {
+	return v.WorktreesContext(context.Background(), dir)
+}
+
+func (v backgroundVCS) IsMainWorktree(dir string) (bool, error) {
+	return v.IsMainWorktreeContext(context.Background(), dir)
+}
+
+// remoteVCSContext is the part of RemoteVCS that backends implement.
+// The remaining methods are provided by backgroundRemoteVCS.
+type remoteVCSContext interface {
+	RemoteHeadContext(ctx context.Context, remoteURL string) (RemoteHead, error)
+}
+
+// backgroundRemoteVCS implements RemoteVCS by calling the Context methods
+// of a backend with context.Background().
+type backgroundRemoteVCS struct{ remoteVCSContext }
+
+func (v backgroundRemoteVCS) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
+	return v.RemoteBranchAndRevisionContext(context.Background(), remoteURL)
+}
+
+func (v backgroundRemoteVCS) RemoteBranchAndRevisionContext(ctx context.Context, remoteURL string) (branch string, revision string, err error) {
+	head, err := v.RemoteHeadContext(ctx, remoteURL)
+	return head.Branch, head.Revision, err
+}
+
+func (v backgroundRemoteVCS) RemoteHead(remoteURL string) (RemoteHead, error) {
+	return v.RemoteHeadContext(context.Background(), remoteURL)
+}
@@ -0,0 +1,75 @@
+package vcsstate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestNewVCSWithOptionsGitPath(t *testing.T) {
+	_, err := NewVCSWithOptions(vcs.ByCmd("git"), &Options{GitPath: "/nonexistent/git"})
+	if err == nil {
+		t.Error("got nil error for nonexistent git binary, want non-nil")
+	}
+	_, err = NewRemoteVCSWithOptions(vcs.ByCmd("git"), &Options{GitPath: "/nonexistent/git"})
+	if err == nil {
+		t.Error("got nil error for nonexistent git binary, want non-nil")
+	}
+	if _, ok := gitVersions.m["/nonexistent/git"]; ok {
+		t.Error("failed probe result for /nonexistent/git is cached, want it retried")
+	}
+}
+
+func TestProbeGitVersionCache(t *testing.T) {
+	runner := &countingRunner{}
+	if v := probeGitVersion(runner, "git"); v.err == nil {
+		t.Fatalf("got nil error from failing runner, want non-nil")
+	}
+	runner.out = "git version 2.39.5\n"
+	for i := 0; i < 2; i++ {
+		v := probeGitVersion(runner, "git")
+		if v.err != nil || v.major != 2 || v.minor != 39 {
+			t.Errorf("got %d.%d, %v, want 2.39, nil", v.major, v.minor, v.err)
+		}
+	}
+	if runner.n != 3 {
+		t.Errorf("got %d runs, want 3: results of runners other than ExecRunner aren't cached", runner.n)
+	}
+
+	// A runner whose type is comparable, but whose dynamic value isn't, must not be used as a map key.
+	wrapped := struct{ Runner }{runnerFunc(runner.Run)}
+	if v := probeGitVersion(wrapped, "git"); v.err != nil || v.major != 2 || v.minor != 39 {
+		t.Errorf("got %d.%d, %v, want 2.39, nil", v.major, v.minor, v.err)
+	}
+
+	if v := probeGitVersion(nil, "git"); v.err != nil {
+		t.Skip("git not available:", v.err)
+	}
+	if _, ok := gitVersions.m["git"]; !ok {
+		t.Error("successful probe result for git with nil runner isn't cached")
+	}
+}
+
+// countingRunner is a Runner that returns out for git --version,
+// or an error if out is empty, and counts how many times it's run.
+type countingRunner struct {
+	out string
+	n   int
+}
+
+func (r *countingRunner) Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+	r.n++
+	if r.out == "" {
+		return nil, []byte("git: transient failure\n"), errors.New("exit status 1")
+	}
+	return []byte(r.out), nil, nil
+}
+
+// runnerFunc is a Runner implemented by a function, whose values aren't comparable.
+type runnerFunc func(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error)
+
+func (f runnerFunc) Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+	return f(ctx, program, args, dir, env)
+}
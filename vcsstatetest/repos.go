@@ -0,0 +1,147 @@
+package vcsstatetest
+
+import (
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TempRepos creates git or hg repositories and remotes on disk for tests,
+// in a temporary directory. Its methods report failures via the testing.TB
+// it was created with.
+type TempRepos struct {
+	Root string // Temporary directory that contains the repositories.
+
+	t   testing.TB
+	vcs string // "git" or "hg".
+}
+
+// NewTempGitRepos returns a TempRepos for git repositories. It skips the test
+// if the git binary is not available. It isolates git from system and user
+// configuration, and sets the author and committer, via environment variables
+// of the test process, so it can't be used in parallel tests.
+func NewTempGitRepos(t testing.TB) *TempRepos {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available:", err)
+	}
+	root := t.TempDir()
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(root, ".gitconfig"))
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	return &TempRepos{Root: root, t: t, vcs: "git"}
+}
+
+// NewTempHgRepos returns a TempRepos for hg repositories. It skips the test
+// if the hg binary is not available. It isolates hg from system and user
+// configuration, enables the shelve extension, and sets the user, via
+// environment variables of the test process, so it can't be used in parallel tests.
+func NewTempHgRepos(t testing.TB) *TempRepos {
+	t.Helper()
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg binary not available:", err)
+	}
+	root := t.TempDir()
+	hgrc := filepath.Join(root, ".hgrc")
+	if err := os.WriteFile(hgrc, []byte("[extensions]\nshelve =\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HGRCPATH", hgrc)
+	t.Setenv("HGPLAIN", "1")
+	t.Setenv("HGUSER", "test <test@example.com>")
+	return &TempRepos{Root: root, t: t, vcs: "hg"}
+}
+
+// Run runs git or hg with args in dir, and returns its output without
+// the trailing newline. If dir is relative, it's relative to Root.
+func (r *TempRepos) Run(dir string, args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command(r.vcs, args...)
+	cmd.Dir = dir
+	if !filepath.IsAbs(dir) {
+		cmd.Dir = filepath.Join(r.Root, dir)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("%s %v: %v: %s", r.vcs, args, err, out)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// Init creates a repository named name, without commits, and returns its directory.
+// For git, its checked out branch is "main".
+func (r *TempRepos) Init(name string) string {
+	r.t.Helper()
+	r.Run("", "init", "--quiet", name)
+	if r.vcs == "git" {
+		// Same as --initial-branch=main, but supported by git versions older than 2.28.
+		r.Run(name, "symbolic-ref", "HEAD", "refs/heads/main")
+	}
+	return filepath.Join(r.Root, name)
+}
+
+// InitRemote creates a repository named name to be used as a remote, and returns
+// its file:// URL. For git, it's a bare repository whose HEAD points to "main".
+// For hg, it's a repository without a working directory checkout.
+func (r *TempRepos) InitRemote(name string) string {
+	r.t.Helper()
+	switch r.vcs {
+	case "git":
+		r.Run("", "init", "--quiet", "--bare", name)
+		r.Run(name, "symbolic-ref", "HEAD", "refs/heads/main")
+	case "hg":
+		r.Run("", "init", "--quiet", name)
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(r.Root, name))}).String()
+}
+
+// Clone clones the repository at remoteURL into a repository named name,
+// and returns its directory.
+func (r *TempRepos) Clone(remoteURL string, name string) string {
+	r.t.Helper()
+	r.Run("", "clone", "--quiet", remoteURL, name)
+	return filepath.Join(r.Root, name)
+}
+
+// WriteFile writes content to the file name in the repository at dir,
+// as returned by Init or Clone.
+func (r *TempRepos) WriteFile(dir string, name string, content string) {
+	r.t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// Commit commits all changes in the working tree of the repository at dir,
+// as returned by Init or Clone, including untracked files, and returns
+// the revision of the new commit. It appends message to a file named "log"
+// first, so there's always a change to commit.
+func (r *TempRepos) Commit(dir string, message string) string {
+	r.t.Helper()
+	f, err := os.OpenFile(filepath.Join(dir, "log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	_, err = f.WriteString(message + "\n")
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	switch r.vcs {
+	case "git":
+		r.Run(dir, "add", "--all")
+		r.Run(dir, "commit", "--quiet", "-m", message)
+		return r.Run(dir, "rev-parse", "HEAD")
+	default:
+		r.Run(dir, "commit", "--addremove", "-m", message)
+		return r.Run(dir, "log", "--rev", ".", "--template", "{node}")
+	}
+}
@@ -0,0 +1,256 @@
+package vcsstatetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+// Transcript is a recording of the commands run by a vcsstate.Runner,
+// in the order they were run. It's stored in golden files as JSON.
+type Transcript struct {
+	Commands []Command `json:"commands"`
+}
+
+// Command is a command in a Transcript, along with its results.
+// Paths under Recorder.Root are recorded relative to "$ROOT".
+type Command struct {
+	Program string   `json:"program"`
+	Args    []string `json:"args,omitempty"`
+	Dir     string   `json:"dir,omitempty"`
+
+	// Env is the environment variables that the command was run with,
+	// but only those that were not set to the same value in the environment
+	// of the recording process, to keep transcripts portable.
+	Env []string `json:"env,omitempty"`
+
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exitCode,omitempty"`
+}
+
+// ReadTranscript reads a transcript from the named file.
+func ReadTranscript(name string) (Transcript, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return Transcript{}, err
+	}
+	var t Transcript
+	err = json.Unmarshal(b, &t)
+	return t, err
+}
+
+// WriteFile writes t to the named file.
+func (t Transcript) WriteFile(name string) error {
+	b, err := json.MarshalIndent(t, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0644)
+}
+
+// Recorder is a vcsstate.Runner that runs commands with another Runner,
+// and records them into a Transcript.
+type Recorder struct {
+	// Runner runs the commands. If nil, vcsstate.ExecRunner is used.
+	Runner vcsstate.Runner
+
+	// Root is a directory, typically a temporary one, which contains
+	// the repositories that commands are run in. It's replaced with "$ROOT"
+	// in the dir, args, env and output of recorded commands.
+	Root string
+
+	mu         sync.Mutex
+	transcript Transcript
+}
+
+// Run implements vcsstate.Runner.
+func (r *Recorder) Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+	runner := r.Runner
+	if runner == nil {
+		runner = vcsstate.ExecRunner{}
+	}
+	stdout, stderr, err = runner.Run(ctx, program, args, dir, env)
+	c := Command{
+		Program: r.relative(program),
+		Dir:     r.relative(dir),
+		Env:     envChanges(env),
+		Stdout:  r.relative(string(stdout)),
+		Stderr:  r.relative(string(stderr)),
+	}
+	for _, a := range args {
+		c.Args = append(c.Args, r.relative(a))
+	}
+	for i, e := range c.Env {
+		c.Env[i] = r.relative(e)
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		// Don't record commands that were canceled, since their results are incomplete.
+		return stdout, stderr, err
+	case errors.As(err, &exitErr):
+		c.ExitCode = exitErr.ExitCode()
+	default:
+		return stdout, stderr, err
+	}
+	r.mu.Lock()
+	r.transcript.Commands = append(r.transcript.Commands, c)
+	r.mu.Unlock()
+	return stdout, stderr, err
+}
+
+// relative replaces occurrences of Root in s with "$ROOT".
+func (r *Recorder) relative(s string) string {
+	if r.Root == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, r.Root, "$ROOT")
+}
+
+// Transcript returns the commands recorded so far.
+func (r *Recorder) Transcript() Transcript {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Transcript{Commands: append([]Command(nil), r.transcript.Commands...)}
+}
+
+// Replayer is a vcsstate.Runner that serves the results of the commands
+// in a Transcript, without running them. Commands must be run in the same
+// order as they were recorded, and with the same args, dir and environment.
+type Replayer struct {
+	Transcript Transcript
+
+	// Root replaces "$ROOT" in the transcript. If empty, "$ROOT" is left as is,
+	// so the repositories are at paths such as "$ROOT/repo".
+	Root string
+
+	mu   sync.Mutex
+	next int // Index of next command in Transcript.
+}
+
+// Run implements vcsstate.Runner. It returns an error if the command
+// doesn't match the next command in the transcript.
+func (r *Replayer) Run(ctx context.Context, program string, args []string, dir string, env []string) (stdout []byte, stderr []byte, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	got := Command{Program: program, Args: args, Dir: dir}
+	if r.next == len(r.Transcript.Commands) {
+		return nil, nil, fmt.Errorf("vcsstatetest: unexpected command %s after end of transcript", got.commandLine())
+	}
+	want := r.absolute(r.Transcript.Commands[r.next])
+	if got.Program != want.Program || !reflect.DeepEqual(got.Args, want.Args) || got.Dir != want.Dir {
+		return nil, nil, fmt.Errorf("vcsstatetest: got command %s in %q, want %s in %q", got.commandLine(), got.Dir, want.commandLine(), want.Dir)
+	}
+	if !envMatches(env, want.Env) {
+		return nil, nil, fmt.Errorf("vcsstatetest: command %s got env changes %q, want %q", got.commandLine(), envChanges(env), want.Env)
+	}
+	r.next++
+	if want.ExitCode != 0 {
+		err = exitError(want.ExitCode)
+	}
+	return []byte(want.Stdout), []byte(want.Stderr), err
+}
+
+// absolute returns c with "$ROOT" replaced by Root.
+func (r *Replayer) absolute(c Command) Command {
+	if r.Root == "" {
+		return c
+	}
+	abs := func(s string) string { return strings.ReplaceAll(s, "$ROOT", r.Root) }
+	c.Program, c.Dir, c.Stdout, c.Stderr = abs(c.Program), abs(c.Dir), abs(c.Stdout), abs(c.Stderr)
+	c.Args = append([]string(nil), c.Args...)
+	for i := range c.Args {
+		c.Args[i] = abs(c.Args[i])
+	}
+	c.Env = append([]string(nil), c.Env...)
+	for i := range c.Env {
+		c.Env[i] = abs(c.Env[i])
+	}
+	return c
+}
+
+// Remaining returns the number of commands in the transcript that
+// haven't been replayed yet. It's zero once the whole transcript is replayed.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Transcript.Commands) - r.next
+}
+
+func (c Command) commandLine() string {
+	return fmt.Sprintf("%q", append([]string{c.Program}, c.Args...))
+}
+
+// exitError is the error for a replayed command that exited with a non-zero
+// exit code. Its message is the same as that of *exec.ExitError.
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+// envMatches reports whether env has the recorded changes want.
+// Variables that were recorded as changes may already be set to the same value
+// in the environment of the current process, so they're looked up in env
+// (or the environment of the current process, if env is nil).
+// Changes to locale variables that weren't recorded are allowed,
+// since the recording process may have already had them set to the same value.
+func envMatches(env []string, want []string) bool {
+	if env == nil {
+		env = os.Environ()
+	}
+	set := make(map[string]bool)
+	for _, e := range env {
+		set[e] = true
+	}
+	for _, e := range want {
+		if !set[e] {
+			return false
+		}
+		delete(set, e)
+	}
+	for _, e := range envChanges(env) {
+		if set[e] && !isLocaleVar(e) {
+			return false // A change that wasn't recorded.
+		}
+	}
+	return true
+}
+
+// isLocaleVar reports whether the environment variable e, e.g., "LANG=C",
+// is one that selects the locale: LANG, LANGUAGE or LC_*.
+func isLocaleVar(e string) bool {
+	name, _, _ := strings.Cut(e, "=")
+	return name == "LANG" || name == "LANGUAGE" || strings.HasPrefix(name, "LC_")
+}
+
+// envChanges returns the variables in env that are not set to the same value
+// in the environment of the current process. A nil env means the environment
+// of the current process, so there are no changes.
+func envChanges(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	current := make(map[string]bool)
+	for _, e := range os.Environ() {
+		current[e] = true
+	}
+	var changes []string
+	for _, e := range env {
+		if !current[e] {
+			changes = append(changes, e)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,477 @@
+// Package vcsstatetest provides in-memory implementations of vcsstate.VCS
+// and vcsstate.RemoteVCS, for testing code that uses them without real
+// repositories and network.
+//
+// The fakes are configured by setting their fields. They may be reconfigured
+// between calls, but not while they're in use by other goroutines.
+package vcsstatetest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+// Repo is the state of a fake local repository.
+type Repo struct {
+	// Branch is the checked out branch.
+	Branch vcsstate.BranchInfo
+
+	// Branches maps local branch names to their revisions.
+	Branches map[string]string
+
+	// Parents maps revisions to the revisions of their parents.
+	// It's used to tell which commits a branch contains,
+	// and how far apart branches are. Revisions without
+	// an entry have no parents.
+	Parents map[string][]string
+
+	// Status is returned by Status, and Files by WorkingTreeStatus.
+	Status string
+	Files  []vcsstate.FileStatus
+
+	// Stash is returned by Stash. It's non-empty if the repository has a stash.
+	Stash string
+
+	// RemoteURL is the remote URL. If empty, the repository has no remote,
+	// and remote-facing methods return vcsstate.ErrNoRemote.
+	RemoteURL string
+
+	// RemoteBranches maps branch names of the remote to their revisions,
+	// as of the last fetch. It's used by RemoteContains and AheadBehind.
+	RemoteBranches map[string]string
+
+	// Upstream is the upstream branch of the checked out branch,
+	// e.g., "origin/main", as reported by Snapshot. Its branch name
+	// after the first slash is looked up in RemoteBranches.
+	Upstream string
+
+	// CachedRemoteDefaultBranch is returned by CachedRemoteDefaultBranch.
+	// If empty, CachedRemoteDefaultBranch returns an error.
+	CachedRemoteDefaultBranch string
+
+	// Worktrees is returned by Worktrees. If empty, the repository has only
+	// its main working tree, rooted at its directory in VCS.Repos.
+	// The repositories of linked working trees can share it, and
+	// IsMainWorktree reports Main of the entry whose Path is their directory.
+	Worktrees []vcsstate.Worktree
+
+	// Errors maps method names, without the Context suffix, to errors that
+	// they return instead of their usual results. E.g., setting "RemoteHead"
+	// to vcsstate.OfflineError{...} simulates being offline.
+	// RemoteBranchAndRevision uses the error for RemoteHead.
+	Errors map[string]error
+}
+
+// VCS is a fake vcsstate.VCS. Its zero value has no repositories.
+type VCS struct {
+	// Repos maps repository root directories to their state.
+	// Methods return an error for directories that aren't in Repos.
+	Repos map[string]*Repo
+
+	// Remote is used to query the remotes of repositories by their RemoteURL.
+	// If nil, all remotes are not found.
+	Remote *RemoteVCS
+
+	// DefaultBranch is returned by NoRemoteDefaultBranch.
+	// If empty, "master" is used.
+	DefaultBranch string
+}
+
+var _ vcsstate.VCS = (*VCS)(nil)
+
+// repo returns the repository rooted at dir, or the error configured
+// for method if there is one.
+func (v *VCS) repo(ctx context.Context, dir string, method string) (*Repo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r, ok := v.Repos[dir]
+	if !ok {
+		return nil, fmt.Errorf("vcsstatetest: no repository at %q", dir)
+	}
+	if err := r.Errors[method]; err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (v *VCS) Status(dir string) (string, error) {
+	return v.StatusContext(context.Background(), dir)
+}
+
+func (v *VCS) StatusContext(ctx context.Context, dir string) (string, error) {
+	r, err := v.repo(ctx, dir, "Status")
+	if err != nil {
+		return "", err
+	}
+	return r.Status, nil
+}
+
+func (v *VCS) WorkingTreeStatus(dir string) ([]vcsstate.FileStatus, error) {
+	return v.WorkingTreeStatusContext(context.Background(), dir)
+}
+
+func (v *VCS) WorkingTreeStatusContext(ctx context.Context, dir string) ([]vcsstate.FileStatus, error) {
+	r, err := v.repo(ctx, dir, "WorkingTreeStatus")
+	if err != nil {
+		return nil, err
+	}
+	return append([]vcsstate.FileStatus(nil), r.Files...), nil
+}
+
+func (v *VCS) Branch(dir string) (string, error) {
+	return v.BranchContext(context.Background(), dir)
+}
+
+func (v *VCS) BranchContext(ctx context.Context, dir string) (string, error) {
+	r, err := v.repo(ctx, dir, "Branch")
+	if err != nil {
+		return "", err
+	}
+	if r.Branch.State == vcsstate.Detached {
+		// Same as git rev-parse --abbrev-ref HEAD.
+		return "HEAD", nil
+	}
+	return r.Branch.Name, nil
+}
+
+func (v *VCS) BranchInfo(dir string) (vcsstate.BranchInfo, error) {
+	return v.BranchInfoContext(context.Background(), dir)
+}
+
+func (v *VCS) BranchInfoContext(ctx context.Context, dir string) (vcsstate.BranchInfo, error) {
+	r, err := v.repo(ctx, dir, "BranchInfo")
+	if err != nil {
+		return vcsstate.BranchInfo{}, err
+	}
+	return r.Branch, nil
+}
+
+func (v *VCS) LocalRevision(dir string, defaultBranch string) (string, error) {
+	return v.LocalRevisionContext(context.Background(), dir, defaultBranch)
+}
+
+func (v *VCS) LocalRevisionContext(ctx context.Context, dir string, defaultBranch string) (string, error) {
+	r, err := v.repo(ctx, dir, "LocalRevision")
+	if err != nil {
+		return "", err
+	}
+	revision, ok := r.Branches[defaultBranch]
+	if !ok {
+		return "", fmt.Errorf("%w %q", vcsstate.ErrUnknownRevision, defaultBranch)
+	}
+	return revision, nil
+}
+
+func (v *VCS) Stash(dir string) (string, error) {
+	return v.StashContext(context.Background(), dir)
+}
+
+func (v *VCS) StashContext(ctx context.Context, dir string) (string, error) {
+	r, err := v.repo(ctx, dir, "Stash")
+	if err != nil {
+		return "", err
+	}
+	return r.Stash, nil
+}
+
+func (v *VCS) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	return v.ContainsContext(context.Background(), dir, revision, defaultBranch)
+}
+
+func (v *VCS) ContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
+	r, err := v.repo(ctx, dir, "Contains")
+	if err != nil {
+		return false, err
+	}
+	tip, ok := r.Branches[defaultBranch]
+	return ok && r.ancestors(tip)[revision], nil
+}
+
+func (v *VCS) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+	return v.RemoteContainsContext(context.Background(), dir, revision, defaultBranch)
+}
+
+func (v *VCS) RemoteContainsContext(ctx context.Context, dir string, revision string, defaultBranch string) (bool, error) {
+	r, err := v.repo(ctx, dir, "RemoteContains")
+	if err != nil {
+		return false, err
+	}
+	if r.RemoteURL == "" {
+		return false, vcsstate.ErrNoRemote
+	}
+	tip, ok := r.RemoteBranches[defaultBranch]
+	return ok && r.ancestors(tip)[revision], nil
+}
+
+func (v *VCS) AheadBehind(dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error) {
+	return v.AheadBehindContext(context.Background(), dir, defaultBranch)
+}
+
+func (v *VCS) AheadBehindContext(ctx context.Context, dir string, defaultBranch string) (ahead int, behind int, mergeBase string, err error) {
+	r, err := v.repo(ctx, dir, "AheadBehind")
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if r.RemoteURL == "" {
+		return 0, 0, "", vcsstate.ErrNoRemote
+	}
+	local, ok := r.Branches[defaultBranch]
+	if !ok {
+		return 0, 0, "", fmt.Errorf("unknown revision %q", defaultBranch)
+	}
+	remote, ok := r.RemoteBranches[defaultBranch]
+	if !ok {
+		return 0, 0, "", fmt.Errorf("unknown remote revision %q", defaultBranch)
+	}
+	ahead, behind, mergeBase = r.aheadBehind(local, remote)
+	return ahead, behind, mergeBase, nil
+}
+
+// ancestors returns the set of revisions reachable from revision, including itself.
+func (r *Repo) ancestors(revision string) map[string]bool {
+	seen := map[string]bool{revision: true}
+	queue := []string{revision}
+	for len(queue) > 0 {
+		rev := queue[0]
+		queue = queue[1:]
+		for _, p := range r.Parents[rev] {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return seen
+}
+
+// aheadBehind reports how many commits local is ahead of and behind remote,
+// and their merge base. If there are several best common ancestors,
+// the merge base is the smallest of them.
+func (r *Repo) aheadBehind(local, remote string) (ahead int, behind int, mergeBase string) {
+	l, rm := r.ancestors(local), r.ancestors(remote)
+	var common []string
+	for rev := range l {
+		if rm[rev] {
+			common = append(common, rev)
+		} else {
+			ahead++
+		}
+	}
+	for rev := range rm {
+		if !l[rev] {
+			behind++
+		}
+	}
+	sort.Strings(common)
+	for _, c := range common {
+		best := true
+		for _, d := range common {
+			if d != c && r.ancestors(d)[c] {
+				best = false // c is an ancestor of another common ancestor d.
+				break
+			}
+		}
+		if best {
+			return ahead, behind, c
+		}
+	}
+	return ahead, behind, ""
+}
+
+func (v *VCS) RemoteURL(dir string) (string, error) {
+	return v.RemoteURLContext(context.Background(), dir)
+}
+
+func (v *VCS) RemoteURLContext(ctx context.Context, dir string) (string, error) {
+	r, err := v.repo(ctx, dir, "RemoteURL")
+	if err != nil {
+		return "", err
+	}
+	if r.RemoteURL == "" {
+		return "", vcsstate.ErrNoRemote
+	}
+	return r.RemoteURL, nil
+}
+
+func (v *VCS) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	return v.RemoteBranchAndRevisionContext(context.Background(), dir)
+}
+
+func (v *VCS) RemoteBranchAndRevisionContext(ctx context.Context, dir string) (branch string, revision string, err error) {
+	head, err := v.RemoteHeadContext(ctx, dir)
+	return head.Branch, head.Revision, err
+}
+
+func (v *VCS) RemoteHead(dir string) (vcsstate.RemoteHead, error) {
+	return v.RemoteHeadContext(context.Background(), dir)
+}
+
+func (v *VCS) RemoteHeadContext(ctx context.Context, dir string) (vcsstate.RemoteHead, error) {
+	r, err := v.repo(ctx, dir, "RemoteHead")
+	if err != nil {
+		return vcsstate.RemoteHead{}, err
+	}
+	if r.RemoteURL == "" {
+		return vcsstate.RemoteHead{}, vcsstate.ErrNoRemote
+	}
+	return v.Remote.RemoteHeadContext(ctx, r.RemoteURL)
+}
+
+func (v *VCS) CachedRemoteDefaultBranch(dir string) (string, error) {
+	return v.CachedRemoteDefaultBranchContext(context.Background(), dir)
+}
+
+func (v *VCS) CachedRemoteDefaultBranchContext(ctx context.Context, dir string) (string, error) {
+	r, err := v.repo(ctx, dir, "CachedRemoteDefaultBranch")
+	if err != nil {
+		return "", err
+	}
+	if r.CachedRemoteDefaultBranch == "" {
+		return "", fmt.Errorf("no cached remote default branch, fall back to NoRemoteDefaultBranch")
+	}
+	return r.CachedRemoteDefaultBranch, nil
+}
+
+func (v *VCS) NoRemoteDefaultBranch() string {
+	if v.DefaultBranch == "" {
+		return "master"
+	}
+	return v.DefaultBranch
+}
+
+func (v *VCS) GuessDefaultBranch(dir string) (branch string, source vcsstate.DefaultBranchSource, err error) {
+	return v.GuessDefaultBranchContext(context.Background(), dir)
+}
+
+// GuessDefaultBranchContext guesses the first of "main", "master" and "trunk"
+// that exists in Branches, and NoRemoteDefaultBranch otherwise.
+func (v *VCS) GuessDefaultBranchContext(ctx context.Context, dir string) (branch string, source vcsstate.DefaultBranchSource, err error) {
+	r, err := v.repo(ctx, dir, "GuessDefaultBranch")
+	if err != nil {
+		return "", 0, err
+	}
+	for _, b := range []string{"main", "master", "trunk"} {
+		if _, ok := r.Branches[b]; ok {
+			return b, vcsstate.LocalBranch, nil
+		}
+	}
+	return v.NoRemoteDefaultBranch(), vcsstate.Builtin, nil
+}
+
+func (v *VCS) Snapshot(dir string) (vcsstate.Snapshot, error) {
+	return v.SnapshotContext(context.Background(), dir)
+}
+
+func (v *VCS) SnapshotContext(ctx context.Context, dir string) (vcsstate.Snapshot, error) {
+	r, err := v.repo(ctx, dir, "Snapshot")
+	if err != nil {
+		return vcsstate.Snapshot{}, err
+	}
+	s := vcsstate.Snapshot{
+		Branch:   r.Branch,
+		Upstream: r.Upstream,
+		Stash:    r.Stash != "",
+	}
+	for _, f := range r.Files {
+		if !f.Ignored {
+			s.Files = append(s.Files, f)
+		}
+	}
+	if _, branch, ok := strings.Cut(r.Upstream, "/"); ok && r.Branch.Revision != "" {
+		if remote, ok := r.RemoteBranches[branch]; ok {
+			s.Ahead, s.Behind, _ = r.aheadBehind(r.Branch.Revision, remote)
+		}
+	}
+	s.DefaultBranch = r.CachedRemoteDefaultBranch
+	if s.DefaultBranch == "" {
+		s.DefaultBranch, _, err = v.GuessDefaultBranchContext(ctx, dir)
+		if err != nil {
+			return vcsstate.Snapshot{}, err
+		}
+	}
+	s.LocalRevision = r.Branches[s.DefaultBranch]
+	return s, nil
+}
+
+func (v *VCS) Worktrees(dir string) ([]vcsstate.Worktree, error) {
+	return v.WorktreesContext(context.Background(), dir)
+}
+
+func (v *VCS) WorktreesContext(ctx context.Context, dir string) ([]vcsstate.Worktree, error) {
+	r, err := v.repo(ctx, dir, "Worktrees")
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Worktrees) == 0 {
+		return []vcsstate.Worktree{{Path: dir, Main: true, Branch: r.Branch}}, nil
+	}
+	return append([]vcsstate.Worktree(nil), r.Worktrees...), nil
+}
+
+func (v *VCS) IsMainWorktree(dir string) (bool, error) {
+	return v.IsMainWorktreeContext(context.Background(), dir)
+}
+
+func (v *VCS) IsMainWorktreeContext(ctx context.Context, dir string) (bool, error) {
+	r, err := v.repo(ctx, dir, "IsMainWorktree")
+	if err != nil {
+		return false, err
+	}
+	for _, w := range r.Worktrees {
+		if w.Path == dir {
+			return w.Main, nil
+		}
+	}
+	return true, nil
+}
+
+// RemoteVCS is a fake vcsstate.RemoteVCS. Its zero value has no remotes.
+type RemoteVCS struct {
+	// Remotes maps remote URLs to their default branch.
+	// Remotes that aren't in Remotes are not found,
+	// so vcsstate.NotFoundError is returned for them.
+	Remotes map[string]vcsstate.RemoteHead
+
+	// Errors maps remote URLs to errors that are returned instead
+	// of their default branch. E.g., vcsstate.AuthenticationError{...}.
+	Errors map[string]error
+}
+
+var _ vcsstate.RemoteVCS = (*RemoteVCS)(nil)
+
+func (rv *RemoteVCS) RemoteBranchAndRevision(remoteURL string) (branch string, revision string, err error) {
+	return rv.RemoteBranchAndRevisionContext(context.Background(), remoteURL)
+}
+
+func (rv *RemoteVCS) RemoteBranchAndRevisionContext(ctx context.Context, remoteURL string) (branch string, revision string, err error) {
+	head, err := rv.RemoteHeadContext(ctx, remoteURL)
+	return head.Branch, head.Revision, err
+}
+
+func (rv *RemoteVCS) RemoteHead(remoteURL string) (vcsstate.RemoteHead, error) {
+	return rv.RemoteHeadContext(context.Background(), remoteURL)
+}
+
+// RemoteHeadContext can be called on a nil *RemoteVCS,
+// in which case all remotes are not found.
+func (rv *RemoteVCS) RemoteHeadContext(ctx context.Context, remoteURL string) (vcsstate.RemoteHead, error) {
+	if err := ctx.Err(); err != nil {
+		return vcsstate.RemoteHead{}, err
+	}
+	if rv == nil {
+		return vcsstate.RemoteHead{}, vcsstate.NotFoundError{Err: fmt.Errorf("vcsstatetest: no remote at %q", remoteURL)}
+	}
+	if err := rv.Errors[remoteURL]; err != nil {
+		return vcsstate.RemoteHead{}, err
+	}
+	head, ok := rv.Remotes[remoteURL]
+	if !ok {
+		return vcsstate.RemoteHead{}, vcsstate.NotFoundError{Err: fmt.Errorf("vcsstatetest: no remote at %q", remoteURL)}
+	}
+	return head, nil
+}
@@ -0,0 +1,218 @@
+package vcsstatetest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/shurcooL/vcsstate"
+	"github.com/shurcooL/vcsstate/vcsstatetest"
+)
+
+func Example() {
+	remote := &vcsstatetest.RemoteVCS{Remotes: map[string]vcsstate.RemoteHead{
+		"https://example.com/repo": {Branch: "main", Revision: "c2", Convention: vcsstate.SymbolicRef},
+	}}
+	var v vcsstate.VCS = &vcsstatetest.VCS{
+		Repos: map[string]*vcsstatetest.Repo{
+			"/repo": {
+				Branch:    vcsstate.BranchInfo{Name: "main", Revision: "c1"},
+				Branches:  map[string]string{"main": "c1"},
+				Parents:   map[string][]string{"c2": {"c1"}},
+				RemoteURL: "https://example.com/repo",
+			},
+		},
+		Remote: remote,
+	}
+
+	branch, revision, err := v.RemoteBranchAndRevision("/repo")
+	if err != nil {
+		panic(err)
+	}
+	local, err := v.LocalRevision("/repo", branch)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(branch, local, revision)
+
+	// Output: main c1 c2
+}
+
+func TestVCS(t *testing.T) {
+	// c4 and c3 are on two sides of a fork from c2.
+	repo := &vcsstatetest.Repo{
+		Branch:         vcsstate.BranchInfo{Name: "main", Revision: "c4"},
+		Branches:       map[string]string{"main": "c4", "feature": "c1"},
+		Parents:        map[string][]string{"c4": {"c2"}, "c3": {"c2"}, "c2": {"c1"}},
+		RemoteURL:      "https://example.com/repo",
+		RemoteBranches: map[string]string{"main": "c3"},
+		Upstream:       "origin/main",
+		Files: []vcsstate.FileStatus{
+			{Path: "a", Unstaged: vcsstate.Modified},
+			{Path: "b", Ignored: true},
+		},
+		Stash: "stash@{0}: WIP on main\n",
+	}
+	v := &vcsstatetest.VCS{Repos: map[string]*vcsstatetest.Repo{"/repo": repo}}
+
+	ahead, behind, mergeBase, err := v.AheadBehind("/repo", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead != 1 || behind != 1 || mergeBase != "c2" {
+		t.Errorf("got %d, %d, %q, want 1, 1, %q", ahead, behind, mergeBase, "c2")
+	}
+	for _, tc := range []struct {
+		revision string
+		local    bool
+		remote   bool
+	}{
+		{"c1", true, true},
+		{"c3", false, true},
+		{"c4", true, false},
+		{"unknown", false, false},
+	} {
+		if got, err := v.Contains("/repo", tc.revision, "main"); err != nil || got != tc.local {
+			t.Errorf("Contains(%q): got %v, %v, want %v", tc.revision, got, err, tc.local)
+		}
+		if got, err := v.RemoteContains("/repo", tc.revision, "main"); err != nil || got != tc.remote {
+			t.Errorf("RemoteContains(%q): got %v, %v, want %v", tc.revision, got, err, tc.remote)
+		}
+	}
+
+	got, err := v.Snapshot("/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := vcsstate.Snapshot{
+		Branch:        vcsstate.BranchInfo{Name: "main", Revision: "c4"},
+		Upstream:      "origin/main",
+		Ahead:         1,
+		Behind:        1,
+		Files:         []vcsstate.FileStatus{{Path: "a", Unstaged: vcsstate.Modified}},
+		Stash:         true,
+		DefaultBranch: "main",
+		LocalRevision: "c4",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestVCSWorktrees(t *testing.T) {
+	worktrees := []vcsstate.Worktree{
+		{Path: "/repo", Main: true, Branch: vcsstate.BranchInfo{Name: "main", Revision: "c1"}},
+		{Path: "/worktree", Branch: vcsstate.BranchInfo{Name: "feature", Revision: "c2"}, Locked: true},
+	}
+	v := &vcsstatetest.VCS{Repos: map[string]*vcsstatetest.Repo{
+		"/repo":     {Branch: worktrees[0].Branch, Worktrees: worktrees},
+		"/worktree": {Branch: worktrees[1].Branch, Worktrees: worktrees},
+		"/other":    {Branch: vcsstate.BranchInfo{Name: "main", Revision: "c3"}},
+	}}
+
+	for _, tc := range []struct {
+		dir  string
+		main bool
+	}{
+		{"/repo", true},
+		{"/worktree", false},
+		{"/other", true},
+	} {
+		if got, err := v.IsMainWorktree(tc.dir); err != nil || got != tc.main {
+			t.Errorf("IsMainWorktree(%q): got %v, %v, want %v", tc.dir, got, err, tc.main)
+		}
+	}
+	if got, err := v.Worktrees("/worktree"); err != nil || !reflect.DeepEqual(got, worktrees) {
+		t.Errorf("Worktrees: got %+v, %v, want %+v", got, err, worktrees)
+	}
+	want := []vcsstate.Worktree{{Path: "/other", Main: true, Branch: vcsstate.BranchInfo{Name: "main", Revision: "c3"}}}
+	if got, err := v.Worktrees("/other"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Worktrees: got %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestVCSErrors(t *testing.T) {
+	offline := vcsstate.OfflineError{Err: errors.New("no network")}
+	v := &vcsstatetest.VCS{
+		Repos: map[string]*vcsstatetest.Repo{
+			"/local":   {Branch: vcsstate.BranchInfo{Name: "main", State: vcsstate.Unborn}},
+			"/offline": {RemoteURL: "https://example.com/repo", Errors: map[string]error{"RemoteHead": offline}},
+			"/missing": {RemoteURL: "https://example.com/missing"},
+		},
+		DefaultBranch: "main",
+	}
+
+	if _, err := v.RemoteURL("/local"); err != vcsstate.ErrNoRemote {
+		t.Errorf("RemoteURL: got error %v, want ErrNoRemote", err)
+	}
+	if _, _, err := v.RemoteBranchAndRevision("/local"); err != vcsstate.ErrNoRemote {
+		t.Errorf("RemoteBranchAndRevision: got error %v, want ErrNoRemote", err)
+	}
+	if _, err := v.RemoteHead("/offline"); !errors.As(err, new(vcsstate.OfflineError)) {
+		t.Errorf("RemoteHead: got error %v, want OfflineError", err)
+	}
+	if _, err := v.RemoteHead("/missing"); !errors.As(err, new(vcsstate.NotFoundError)) {
+		t.Errorf("RemoteHead: got error %v, want NotFoundError", err)
+	}
+	if _, err := v.Status("/nonexistent"); err == nil {
+		t.Error("Status: got nil error for unknown directory, want non-nil")
+	}
+	if branch, source, err := v.GuessDefaultBranch("/local"); err != nil || branch != "main" || source != vcsstate.Builtin {
+		t.Errorf("GuessDefaultBranch: got %q, %v, %v, want %q, Builtin, nil", branch, source, err, "main")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := v.BranchInfoContext(ctx, "/local"); err != context.Canceled {
+		t.Errorf("BranchInfoContext: got error %v, want context.Canceled", err)
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	r := &vcsstatetest.Replayer{
+		Transcript: vcsstatetest.Transcript{Commands: []vcsstatetest.Command{
+			{Program: "git", Args: []string{"rev-parse", "HEAD"}, Dir: "$ROOT/repo", Stdout: "c1\n"},
+			{Program: "git", Args: []string{"symbolic-ref", "--quiet", "HEAD"}, Dir: "$ROOT/repo", ExitCode: 1},
+		}},
+		Root: "/tmp/x",
+	}
+	ctx := context.Background()
+	stdout, _, err := r.Run(ctx, "git", []string{"rev-parse", "HEAD"}, "/tmp/x/repo", nil)
+	if err != nil || string(stdout) != "c1\n" {
+		t.Errorf("got %q, %v, want %q, nil", stdout, err, "c1\n")
+	}
+	if _, _, err := r.Run(ctx, "git", []string{"status"}, "/tmp/x/repo", nil); err == nil {
+		t.Error("got nil error for command that doesn't match transcript, want non-nil")
+	}
+	_, _, err = r.Run(ctx, "git", []string{"symbolic-ref", "--quiet", "HEAD"}, "/tmp/x/repo", nil)
+	if err == nil || err.Error() != "exit status 1" {
+		t.Errorf("got error %v, want exit status 1", err)
+	}
+	if n := r.Remaining(); n != 0 {
+		t.Errorf("got %d remaining commands, want 0", n)
+	}
+}
+
+func TestReplayerLocale(t *testing.T) {
+	t.Setenv("LANG", "C")
+	env := append(os.Environ(), "LANG=en_US.UTF-8")
+	ctx := context.Background()
+
+	// The recording process had LANG=en_US.UTF-8 already, so it wasn't recorded as a change.
+	r := &vcsstatetest.Replayer{Transcript: vcsstatetest.Transcript{Commands: []vcsstatetest.Command{
+		{Program: "git", Args: []string{"status"}},
+	}}}
+	if _, _, err := r.Run(ctx, "git", []string{"status"}, "", env); err != nil {
+		t.Errorf("got error %v for unrecorded LANG change, want nil", err)
+	}
+
+	// A recorded LANG change must still be made.
+	r = &vcsstatetest.Replayer{Transcript: vcsstatetest.Transcript{Commands: []vcsstatetest.Command{
+		{Program: "git", Args: []string{"status"}, Env: []string{"LANG=en_US.UTF-8"}},
+	}}}
+	if _, _, err := r.Run(ctx, "git", []string{"status"}, "", nil); err == nil {
+		t.Error("got nil error for missing recorded LANG change, want non-nil")
+	}
+}
@@ -0,0 +1,80 @@
+package vcsstate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Worktree is a working tree of a repository.
+type Worktree struct {
+	Path   string     // Root directory of the working tree.
+	Main   bool       // Whether it's the main working tree, rather than a linked one.
+	Bare   bool       // Whether the main working tree is a bare repository, so it has no files.
+	Branch BranchInfo // What is checked out. Zero if Bare.
+
+	// Locked reports whether the linked working tree is locked,
+	// so it can't be pruned, moved or removed.
+	Locked     bool
+	LockReason string // Reason given when it was locked, if any.
+
+	// Prunable reports whether the linked working tree can be pruned,
+	// e.g., because its directory was deleted. A locked working tree is never
+	// prunable. Only git 2.31+ reports it, unless the repository is read directly.
+	Prunable    bool
+	PruneReason string // Reason it can be pruned, if known.
+}
+
+// parseGitWorktreeList parses the output of git worktree list --porcelain.
+// Each working tree is a block of lines separated by an empty line,
+// and the first one is the main working tree.
+func parseGitWorktreeList(out []byte) ([]Worktree, error) {
+	var worktrees []Worktree
+	for _, block := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n\n") {
+		if block == "" {
+			continue
+		}
+		var w Worktree
+		for _, line := range strings.Split(block, "\n") {
+			key, value, _ := strings.Cut(line, " ")
+			switch key {
+			case "worktree":
+				w.Path = value
+			case "HEAD":
+				if strings.Trim(value, "0") != "" {
+					w.Branch.Revision = value
+				}
+			case "branch":
+				w.Branch.Name = strings.TrimPrefix(value, "refs/heads/")
+			case "detached":
+				w.Branch.State = Detached
+			case "bare":
+				w.Bare = true
+			case "locked":
+				w.Locked, w.LockReason = true, value
+			case "prunable":
+				w.Prunable, w.PruneReason = true, value
+			}
+		}
+		if w.Path == "" {
+			return nil, fmt.Errorf("git worktree list: missing worktree line in %q", block)
+		}
+		if w.Branch.State == Named && w.Branch.Name != "" && w.Branch.Revision == "" {
+			w.Branch.State = Unborn
+		}
+		w.Main = len(worktrees) == 0
+		worktrees = append(worktrees, w)
+	}
+	if len(worktrees) == 0 {
+		return nil, fmt.Errorf("git worktree list: no worktrees in output")
+	}
+	sortLinkedWorktrees(worktrees)
+	return worktrees, nil
+}
+
+// sortLinkedWorktrees sorts linked working trees by path,
+// keeping the main working tree first, like git worktree list does.
+func sortLinkedWorktrees(worktrees []Worktree) {
+	linked := worktrees[1:]
+	sort.Slice(linked, func(i, j int) bool { return linked[i].Path < linked[j].Path })
+}
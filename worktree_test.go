@@ -0,0 +1,52 @@
+package vcsstate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGitWorktreeList(t *testing.T) {
+	const (
+		oid  = "7cafcd837844e784b526369c9bce262804aebc60"
+		zero = "0000000000000000000000000000000000000000"
+	)
+	tests := []struct {
+		in   string
+		want []Worktree
+	}{
+		{
+			in: "worktree /repo\nHEAD " + zero + "\nbranch refs/heads/main\n\n",
+			want: []Worktree{
+				{Path: "/repo", Main: true, Branch: BranchInfo{State: Unborn, Name: "main"}},
+			},
+		},
+		{
+			in: "worktree /repo.git\nbare\n\n" +
+				"worktree /wt/z\nHEAD " + oid + "\nbranch refs/heads/feature\nprunable gitdir file points to non-existent location\n\n" +
+				"worktree /wt/a\nHEAD " + oid + "\ndetached\nlocked on a removable drive\n\n" +
+				"worktree /wt/b\nHEAD " + oid + "\ndetached\nlocked\n\n",
+			want: []Worktree{
+				{Path: "/repo.git", Main: true, Bare: true},
+				{Path: "/wt/a", Branch: BranchInfo{State: Detached, Revision: oid}, Locked: true, LockReason: "on a removable drive"},
+				{Path: "/wt/b", Branch: BranchInfo{State: Detached, Revision: oid}, Locked: true},
+				{Path: "/wt/z", Branch: BranchInfo{Name: "feature", Revision: oid}, Prunable: true, PruneReason: "gitdir file points to non-existent location"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parseGitWorktreeList([]byte(test.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got %+v, want %+v", got, test.want)
+		}
+	}
+
+	for _, in := range []string{"", "HEAD " + oid + "\n\n"} {
+		if _, err := parseGitWorktreeList([]byte(in)); err == nil {
+			t.Errorf("got nil error for %q, want non-nil", in)
+		}
+	}
+}